@@ -0,0 +1,108 @@
+package bernie
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SetArtifactDir makes p copy the declared outputs of each finished attempt
+// into dir/TASK/ATTEMPT.
+// If dir is empty, outputs are not collected.
+func (p *WorkerPool) SetArtifactDir(dir string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.artifactDir = dir
+}
+
+// AttemptArtifactDir returns the directory holding the artifacts of
+// attempt n of t, counting from 1.
+// It returns an empty string if artifacts are not collected.
+func (p *WorkerPool) AttemptArtifactDir(t *Task, n int) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.artifactDir == "" || !IsPathElem(t.Name) {
+		return ""
+	}
+	return filepath.Join(p.artifactDir, t.Name, strconv.Itoa(n))
+}
+
+// IsPathElem reports whether name can be used as a single path element.
+func IsPathElem(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// collectArtifacts copies the outputs of t's last attempt into the artifact dir.
+func (p *WorkerPool) collectArtifacts(t *Task) {
+	if len(t.Outputs) == 0 {
+		return
+	}
+	dst := p.AttemptArtifactDir(t, len(t.Status().Attempts))
+	if dst == "" {
+		return
+	}
+	if err := copyOutputs(t.WD, t.Outputs, dst); err != nil {
+		p.log.Errorf("unable to collect artifacts of %s: %v", t.Name, err)
+	}
+}
+
+// copyOutputs copies the files under wd matched by globs into dst,
+// keeping their paths relative to wd.
+// Matched directories are copied recursively.
+func copyOutputs(wd string, globs []string, dst string) error {
+	for _, g := range globs {
+		if filepath.IsAbs(g) {
+			return fmt.Errorf("output %q is not relative to the working directory", g)
+		}
+		matches, err := filepath.Glob(filepath.Join(wd, g))
+		if err != nil {
+			return fmt.Errorf("bad output pattern %q: %v", g, err)
+		}
+		for _, m := range matches {
+			rel, err := filepath.Rel(wd, m)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("output %q escapes the working directory", g)
+			}
+			err = filepath.Walk(m, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.Mode().IsRegular() {
+					return nil
+				}
+				rel, err := filepath.Rel(wd, path)
+				if err != nil {
+					return err
+				}
+				return copyFile(path, filepath.Join(dst, rel))
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -21,6 +21,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/uluyol/bernie/internal"
@@ -64,6 +65,8 @@ type WorkerStatus struct {
 	InitTask    *Task
 	Initialized bool
 	Killed      bool
+	// Draining is set once the worker should not be given new tasks.
+	Draining bool
 }
 
 func (s WorkerStatus) IsFree() bool {
@@ -72,9 +75,15 @@ func (s WorkerStatus) IsFree() bool {
 
 func (s WorkerStatus) HumanFriendly(maxFails int) string {
 	if s.Initialized {
-		if s.FailedTasks > maxFails {
+		if s.FailedTasks >= maxFails {
 			return "Dead"
 		}
+		if s.Draining {
+			if s.RunningTask == nil {
+				return "Drained"
+			}
+			return "Draining"
+		}
 		if s.RunningTask == nil {
 			return "Ready"
 		}
@@ -99,8 +108,10 @@ type Worker struct {
 	name     string
 	manifest string
 
-	mu     sync.Mutex
-	status WorkerStatus
+	mu          sync.Mutex
+	status      WorkerStatus
+	cgroupRoot  string
+	manifestEnv []string
 
 	initMu sync.Mutex
 }
@@ -128,6 +139,24 @@ func (w *Worker) ResetFailures() {
 	w.mu.Unlock()
 }
 
+// Drain stops w from being given new tasks.
+// A task that w is running is left to finish.
+func (w *Worker) Drain() {
+	w.mu.Lock()
+	w.status.Draining = true
+	w.mu.Unlock()
+}
+
+// SetCgroupRoot makes w run each attempt in its own cgroup under root
+// to account for the attempt's resource usage.
+// root must be a writable cgroup v2 directory.
+// If root is empty, usage is gathered from the attempt's process group.
+func (w *Worker) SetCgroupRoot(root string) {
+	w.mu.Lock()
+	w.cgroupRoot = root
+	w.mu.Unlock()
+}
+
 func (w *Worker) Name() string     { return w.name }
 func (w *Worker) Manifest() string { return w.manifest }
 
@@ -162,6 +191,8 @@ func (w *Worker) init(t *Task) {
 	} else {
 		w.mu.Lock()
 		w.status.Initialized = true
+		// Failed tries of the init task do not count against w.
+		w.status.FailedTasks = 0
 		w.mu.Unlock()
 	}
 	w.log.Debugf("updated status")
@@ -172,22 +203,18 @@ var errTaskKilled = errors.New("task killed")
 
 func (w *Worker) Run(t *Task) {
 	w.log.Debugf("setup run env")
-	wdir, setupFine := w.setupRun(t)
+	wdir, cgroup, setupFine := w.setupRun(t)
 	if !setupFine {
 		return
 	}
 	w.log.Debugf("worker dir is: %s", wdir)
+	start := time.Now()
 
 	session := "bernie-task+" + internal.Base62(randInt32())
 	cmd := exec.Command("tmux",
 		"new-session", "-d", "-s", session, filepath.Join(wdir, "do.sh"), ";",
 		"set", "remain-on-exit", "on")
-	cmd.Env = append(os.Environ(), []string{
-		"WORKER_MANIFEST=" + filepath.Join(wdir, "wmanifest"),
-	}...)
-	if t.Env != nil {
-		cmd.Env = append(cmd.Env, t.Env...)
-	}
+	cmd.Env = t.launchEnv(wdir, w.ManifestEnv())
 	cmd.Dir = t.WD
 
 	w.log.Debugf("run command")
@@ -201,11 +228,26 @@ func (w *Worker) Run(t *Task) {
 	w.log.Debugf("finished setting new status")
 
 	var retErr error
+	exitCode := -1
+	var pgid int
+	var maxRSS int64
 	sleeper := responsiveSleeper{
 		Max: 10 * time.Second,
 		Cur: 125 * time.Millisecond,
 	}
 	for {
+		if pgid == 0 {
+			// do.sh leads the process group of the attempt.
+			if pgid = readPid(wdir); pgid != 0 {
+				t.setPgid(pgid)
+			}
+		}
+		if cgroup == "" && pgid != 0 {
+			if rss := pgroupRSS(pgid); rss > maxRSS {
+				maxRSS = rss
+			}
+		}
+
 		if st := t.Status(); st.Killed || st.Err == errTaskKilled {
 			retErr = errTaskKilled
 			break
@@ -220,8 +262,9 @@ func (w *Worker) Run(t *Task) {
 					code, err := strconv.Atoi(strings.TrimSpace(string(b)))
 					if err != nil {
 						retErr = fmt.Errorf("unable to parse error code from done file: %v", err)
-					} else if code != 0 {
-						retErr = fmt.Errorf("exit status %d", code)
+					} else {
+						exitCode = code
+						retErr = t.exitPolicy().classify(code)
 					}
 				}
 				break
@@ -240,12 +283,39 @@ func (w *Worker) Run(t *Task) {
 
 		sleeper.Sleep()
 	}
+	var usage Usage
+	var uerr error
+	if cgroup != "" {
+		usage, uerr = readCgroupUsage(cgroup)
+		if err := removeCgroup(cgroup); err != nil {
+			w.log.Infof("unable to remove cgroup %s: %v", cgroup, err)
+		}
+	} else {
+		usage, uerr = readShellUsage(wdir)
+		usage.MaxRSS = maxRSS
+	}
+	if uerr != nil {
+		w.log.Debugf("unable to read usage of %s: %v", t.Name, uerr)
+	}
+
+	end := time.Now()
+	suspended := t.endSuspension(end)
 	st = t.Status()
 	st.Done = true
 	st.Err = retErr
 	if st.Err == errWorkerKilled {
 		st.Tries--
 	}
+	st.Attempts = append(st.Attempts[:len(st.Attempts):len(st.Attempts)], Attempt{
+		Worker:    w.name,
+		Start:     start,
+		End:       end,
+		Suspended: suspended,
+		Err:       retErr,
+		Usage:     usage,
+		ExitCode:  exitCode,
+		Signal:    exitSignal(exitCode),
+	})
 	t.setStatus(st)
 
 	if err := os.RemoveAll(wdir); err != nil {
@@ -255,56 +325,83 @@ func (w *Worker) Run(t *Task) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	w.status.RunningTask = nil
-	if err != nil && err != errTaskKilled {
+	if retErr != nil && retErr != errTaskKilled && retErr != errWorkerKilled {
 		w.status.FailedTasks++
 	}
 }
 
-func (w *Worker) setupRun(t *Task) (wdir string, ok bool) {
+func (w *Worker) setupRun(t *Task) (wdir, cgroup string, ok bool) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	if w.status.RunningTask != nil {
 		// already running something
-		return "", false
+		return "", "", false
 	}
 	if w.status.Killed {
 		panic("trying to run on killed node")
-		return "", false
+		return "", "", false
 	}
 
 	st := t.Status()
 	st.Done = false
+	st.Cached = false
+	st.CachedFrom = ""
+	st.CachedOutput = ""
+	st.Suspended = false
+	st.SuspendedFor = 0
 	st.Runner = w
 	st.Tries++
 	defer t.setStatus(st)
 	wdir, err := ioutil.TempDir("", "bernie-task")
 	if err != nil {
 		st.Err = err
-		return "", false
+		return "", "", false
 	}
 	err = ioutil.WriteFile(filepath.Join(wdir, "wmanifest"), []byte(w.Manifest()), 0666)
 	if err != nil {
 		st.Err = err
-		return "", false
+		return "", "", false
+	}
+	if w.cgroupRoot != "" {
+		cgroup = filepath.Join(w.cgroupRoot, filepath.Base(wdir))
+		if err := os.Mkdir(cgroup, 0755); err != nil {
+			w.log.Errorf("unable to create cgroup, falling back to process group: %v", err)
+			cgroup = ""
+		}
 	}
 	var buf bytes.Buffer
+	// The tmux server may have been started with another environment,
+	// so the task's environment is set up by do.sh.
+	err = ioutil.WriteFile(filepath.Join(wdir, "env.sh"), exportScript(t.launchEnv(wdir, w.manifestEnv)), 0600)
+	if err != nil {
+		st.Err = err
+		return "", "", false
+	}
 	buf.WriteString("#!/bin/sh\n")
+	buf.WriteString(". '" + filepath.Join(wdir, "env.sh") + "'\n")
+	buf.WriteString("echo $$ > '" + filepath.Join(wdir, "pid") + "'\n")
+	if cgroup != "" {
+		buf.WriteString("echo $$ > '" + filepath.Join(cgroup, "cgroup.procs") + "'\n")
+	}
 	for _, p := range t.Cmd {
 		buf.WriteString(strconv.Quote(p))
 		buf.WriteByte(' ')
 	}
 	buf.WriteByte('\n')
-	buf.WriteString("st=$?\necho exit status $st\necho $st > '")
+	buf.WriteString("st=$?\necho exit status $st\n")
+	buf.WriteString("cat /proc/$$/stat > '" + filepath.Join(wdir, "stat") + "' 2>/dev/null\n")
+	buf.WriteString("cat /proc/$$/io > '" + filepath.Join(wdir, "io") + "' 2>/dev/null\n")
+	buf.WriteString("echo $st > '")
 	buf.WriteString(filepath.Join(wdir, "done"))
 	buf.WriteString("'\n")
 	err = ioutil.WriteFile(filepath.Join(wdir, "do.sh"), buf.Bytes(), 0777)
 	if err != nil {
 		st.Err = err
-		return "", false
+		return "", "", false
 	}
 
 	w.status.RunningTask = t
-	return wdir, true
+	return wdir, cgroup, true
 }
 
 // circular, doubly linked list
@@ -333,6 +430,11 @@ func listPushTail(list *taskNode, t *Task) {
 	list.prev = n
 }
 
+func listRemove(n *taskNode) {
+	n.prev.next = n.next
+	n.next.prev = n.prev
+}
+
 type WorkerPool struct {
 	log               Logger
 	maxTaskTries      int
@@ -343,6 +445,28 @@ type WorkerPool struct {
 	initTask *Task
 	pool     []*Worker
 	free     []*Worker
+	shared   *SharedPool
+	share    float64
+
+	failedOn      map[*Task]map[*Worker]bool
+	strictRetries bool
+	artifactDir   string
+	cache         map[string]*cacheEntry
+	exitPolicy    *ExitPolicy
+	env           []string
+	secrets       map[string]string
+
+	initVersion  int
+	initVersions map[*Worker]int
+	maxReinit    int
+	reiniting    int
+
+	paused *PauseInfo
+
+	running   map[*Task]bool // tasks taken off the queue that have not finished or been queued again
+	emptySent bool           // QueueEmpty was sent and no task was submitted since
+	onEvent   func(Event)
+	onInit    func(*Worker, Attempt)
 }
 
 func NewWorkerPool(log Logger, maxFailures, maxTries int, initTask *Task) *WorkerPool {
@@ -351,47 +475,128 @@ func NewWorkerPool(log Logger, maxFailures, maxTries int, initTask *Task) *Worke
 		maxTaskTries:      maxTries,
 		maxWorkerFailures: maxFailures,
 		initTask:          initTask,
+		failedOn:          make(map[*Task]map[*Worker]bool),
+		cache:             make(map[string]*cacheEntry),
+		initVersion:       1,
+		initVersions:      make(map[*Worker]int),
+		running:           make(map[*Task]bool),
 	}
 	p.queued.next = &p.queued
 	p.queued.prev = &p.queued
 	return p
 }
 
-func (p *WorkerPool) AllowableTaskTries() int { return p.maxTaskTries }
+func (p *WorkerPool) AllowableTaskTries() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.maxTaskTries
+}
 
 func (p *WorkerPool) AllowableWorkerFailures() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.maxWorkerFailures
 }
 
+// SetAllowableTaskTries sets how many times a failing task is tried.
+// It applies to tasks the next time they fail.
+func (p *WorkerPool) SetAllowableTaskTries(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maxTaskTries = n
+}
+
+// SetAllowableWorkerFailures sets how many tasks may fail on a worker
+// before it stops being given tasks.
+// Raising it puts idle workers that were taken out of service back in it,
+// and lowering it takes idle workers that have failed n tasks out of service.
+func (p *WorkerPool) SetAllowableWorkerFailures(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []*Worker
+	for _, w := range p.pool {
+		// Workers that are initializing are freed once they are done.
+		wst := w.Status()
+		if wst.FailedTasks >= p.maxWorkerFailures && wst.Initialized && p.initVersions[w] == p.initVersion {
+			out = append(out, w)
+		}
+	}
+	p.maxWorkerFailures = n
+	dropFailed(&p.free, n)
+	addFree(&p.free, out, n)
+	p.schedule()
+}
+
+// Close removes p's workers and queued tasks and detaches p from its shared pool.
+// Running tasks are left alone and should be killed by the caller.
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	p.queued.next = &p.queued
+	p.queued.prev = &p.queued
+	p.failedOn = make(map[*Task]map[*Worker]bool)
+	shared := p.shared
+	p.shared = nil
+	p.mu.Unlock()
+
+	p.Remove(func(ws []*Worker) []int {
+		all := make([]int, len(ws))
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	})
+	if shared != nil {
+		shared.Detach(p)
+	}
+}
+
+// Share returns the weight p was attached to a SharedPool with,
+// or 0 if p only uses its own workers.
+func (p *WorkerPool) Share() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.share
+}
+
 func (p *WorkerPool) Remove(selector func([]*Worker) []int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	removeWorkers(&p.pool, &p.free, selector)
+	for w := range p.initVersions {
+		if !containsWorker(p.pool, w) {
+			delete(p.initVersions, w)
+		}
+	}
+	p.schedule()
+}
 
-	toremove := selector(p.pool)
+// removeWorkers kills the workers in pool chosen by selector and drops them
+// from both pool and free.
+func removeWorkers(pool, free *[]*Worker, selector func([]*Worker) []int) {
+	toremove := selector(*pool)
 
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 	var wg sync.WaitGroup
 	wg.Add(len(toremove))
 	for _, i := range toremove {
-		go func() {
-			p.pool[i].Kill(ctx)
+		go func(w *Worker) {
+			w.Kill(ctx)
 			wg.Done()
-		}()
+		}((*pool)[i])
 	}
 	wg.Wait()
 
 	sort.Sort(sort.Reverse(sort.IntSlice(toremove)))
 	for _, i := range toremove {
-		p.pool = append(p.pool[:i], p.pool[i+1:]...)
+		*pool = append((*pool)[:i], (*pool)[i+1:]...)
 	}
 
-	toremove = selector(p.free)
+	toremove = selector(*free)
 	sort.Sort(sort.Reverse(sort.IntSlice(toremove)))
 	for _, i := range toremove {
-		p.free = append(p.free[:i], p.free[i+1:]...)
+		*free = append((*free)[:i], (*free)[i+1:]...)
 	}
-
-	p.schedule()
 }
 
 func (p *WorkerPool) WorkersCopy() []*Worker {
@@ -411,93 +616,586 @@ func (p *WorkerPool) Grow(ws []*Worker) {
 
 	p.pool = append(p.pool, ws...)
 	for _, w := range ws {
-		go func(w *Worker, t *Task, maxFail int) {
+		go func(w *Worker, t *Task, version, maxFail int) {
 			for i := 0; i <= maxFail; i++ {
 				w.Init(t)
+				p.initRan(w, t)
 				if w.Status().Initialized {
 					p.mu.Lock()
 					defer p.mu.Unlock()
 					defer p.schedule()
+					p.initVersions[w] = version
 					addFree(&p.free, []*Worker{w}, p.maxWorkerFailures)
 					return
 				}
 			}
-		}(w, p.initTask.FreshCopy(), p.maxWorkerFailures)
+		}(w, p.initWithDefaults(), p.initVersion, p.maxWorkerFailures)
+	}
+}
+
+// SetInitTask replaces p's init task and bumps its version.
+// Workers are re-initialized with t as they become idle,
+// with at most maxReinit re-initializing at a time,
+// counting shared workers that are re-initialized for p.
+// It returns the new version.
+func (p *WorkerPool) SetInitTask(t *Task, maxReinit int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if maxReinit < 1 {
+		maxReinit = 1
+	}
+	p.initTask = t
+	p.initVersion++
+	p.maxReinit = maxReinit
+	p.schedule()
+	return p.initVersion
+}
+
+func (p *WorkerPool) InitTask() *Task {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.initTask
+}
+
+func (p *WorkerPool) InitVersion() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.initVersion
+}
+
+// WorkerInitVersion returns the version of the init task that w last
+// completed for p, or 0 if it has not completed one.
+func (p *WorkerPool) WorkerInitVersion(w *Worker) int {
+	p.mu.Lock()
+	v, ok := p.initVersions[w]
+	shared := p.shared
+	p.mu.Unlock()
+	if !ok && shared != nil {
+		return shared.initVersion(p, w)
+	}
+	return v
+}
+
+// versionedInit is like freshInit but also returns the init task's version.
+func (p *WorkerPool) versionedInit() (*Task, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.initWithDefaults(), p.initVersion
+}
+
+// rollInit starts re-initializing free workers whose init task is out of date.
+//
+// Make sure that p.mu is held before calling this method!
+func (p *WorkerPool) rollInit() {
+	for i := len(p.free) - 1; i >= 0 && p.reiniting < p.maxReinit; i-- {
+		w := p.free[i]
+		if p.initVersions[w] == p.initVersion {
+			continue
+		}
+		p.free = append(p.free[:i], p.free[i+1:]...)
+		p.reiniting++
+		go p.reinit(w, p.initWithDefaults(), p.initVersion, p.maxWorkerFailures)
 	}
 }
 
+func (p *WorkerPool) reinit(w *Worker, t *Task, version, maxFail int) {
+	for i := 0; i <= maxFail; i++ {
+		if i > 0 {
+			t = t.FreshCopy()
+		}
+		w.Reinit(t)
+		p.initRan(w, t)
+		if w.Status().Initialized {
+			break
+		}
+	}
+
+	p.mu.Lock()
+	p.reiniting--
+	if w.Status().Initialized {
+		p.initVersions[w] = version
+		addFree(&p.free, []*Worker{w}, p.maxWorkerFailures)
+	} else {
+		p.log.Errorf("unable to re-initialize %s to version %d", w.Name(), version)
+	}
+	p.schedule()
+	shared := p.shared
+	p.mu.Unlock()
+	if shared != nil {
+		// Shared workers may have been waiting for the re-init to end.
+		shared.schedule()
+	}
+}
+
+// startReinit counts a re-init of a shared worker for p
+// if fewer than maxReinit workers are re-initializing.
+// It reports whether the re-init may start.
+func (p *WorkerPool) startReinit() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.reiniting >= p.maxReinit {
+		return false
+	}
+	p.reiniting++
+	return true
+}
+
+// endReinit ends a re-init started with startReinit.
+func (p *WorkerPool) endReinit() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reiniting--
+	p.schedule()
+}
+
+// addFree adds the workers of ws that may be given tasks to dst
+// unless they are already in it.
 func addFree(dst *[]*Worker, ws []*Worker, maxFailures int) {
 	for _, w := range ws {
 		wst := w.Status()
-		if wst.IsFree() && wst.FailedTasks < maxFailures && !wst.Killed {
-			*dst = append(*dst, ws...)
+		if wst.IsFree() && wst.FailedTasks < maxFailures && !wst.Killed && !wst.Draining && !containsWorker(*dst, w) {
+			*dst = append(*dst, w)
 		}
 	}
 }
 
-func (p *WorkerPool) Submit(ts ...*Task) error {
+// dropDraining removes the workers that are draining from free.
+func dropDraining(free *[]*Worker) {
+	ws := (*free)[:0]
+	for _, w := range *free {
+		if !w.Status().Draining {
+			ws = append(ws, w)
+		}
+	}
+	*free = ws
+}
+
+// ResetFailures resets the count of tasks that w, a worker of p, has failed
+// and puts w back in service if it is idle.
+func (p *WorkerPool) ResetFailures(w *Worker) {
+	w.ResetFailures()
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	defer p.schedule()
+	// Workers that are initializing are freed once they are done.
+	if containsWorker(p.pool, w) && w.Status().Initialized && p.initVersions[w] == p.initVersion {
+		addFree(&p.free, []*Worker{w}, p.maxWorkerFailures)
+		p.schedule()
+	}
+}
+
+// dropFailed removes the workers that have failed maxFailures tasks from free.
+func dropFailed(free *[]*Worker, maxFailures int) {
+	ws := (*free)[:0]
+	for _, w := range *free {
+		if w.Status().FailedTasks < maxFailures {
+			ws = append(ws, w)
+		}
+	}
+	*free = ws
+}
+
+func (p *WorkerPool) Submit(ts ...*Task) error {
+	p.mu.Lock()
 	for _, t := range ts {
 		p.submit(t)
 	}
+	p.schedule()
+	shared := p.shared
+	p.mu.Unlock()
+	if shared != nil {
+		shared.schedule()
+	}
 	return nil
 }
 
 func (p *WorkerPool) submit(t *Task) error {
+	p.applyDefaults(t)
+	p.emptySent = false
 	listPushTail(&p.queued, t)
 	return nil
 }
 
+// SetExitPolicy sets the policy used for tasks that do not have their own.
+func (p *WorkerPool) SetExitPolicy(policy *ExitPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.exitPolicy = policy
+}
+
+func (p *WorkerPool) ExitPolicy() *ExitPolicy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exitPolicy
+}
+
+// requeue puts t back at the front of the queue without counting a try.
+func (p *WorkerPool) requeue(t *Task) {
+	p.mu.Lock()
+	delete(p.running, t)
+	listPushHead(&p.queued, t)
+	p.schedule()
+	p.mu.Unlock()
+}
+
+// ErrNotFinished is returned by Rerun for tasks that are queued or running.
+var ErrNotFinished = errors.New("task has not finished")
+
+// Rerun queues t, which must have finished or been killed, to run again.
+// Its tries are reset but its earlier attempts are kept,
+// so new attempts are numbered after them.
+// A cached task runs again instead of being completed from its own result.
+func (p *WorkerPool) Rerun(t *Task) error {
+	p.mu.Lock()
+	st := t.Status()
+	if p.running[t] || p.isQueued(t) || !(st.Done || st.Killed) {
+		p.mu.Unlock()
+		return ErrNotFinished
+	}
+	// Count t as running until it is queued so that it is not rerun twice.
+	p.running[t] = true
+	delete(p.failedOn, t)
+	// Forget t's own result so that t is not completed from it.
+	if e, ok := p.cache[st.CacheKey]; ok && e.task == t.Name {
+		delete(p.cache, st.CacheKey)
+	}
+	p.mu.Unlock()
+
+	t.killSession(context.Background())
+	t.resetForRerun()
+	p.resubmit(t)
+	return nil
+}
+
+// resubmit queues t, which has been counted as running, again.
+func (p *WorkerPool) resubmit(t *Task) {
+	p.mu.Lock()
+	delete(p.running, t)
+	p.submit(t)
+	p.schedule()
+	shared := p.shared
+	p.mu.Unlock()
+	if shared != nil {
+		shared.schedule()
+	}
+}
+
+// isQueued reports whether t is in p's queue and may still run.
+//
+// Make sure that p.mu is held before calling this method!
+func (p *WorkerPool) isQueued(t *Task) bool {
+	for n := p.queued.next; n != &p.queued; n = n.next {
+		if n.t == t && !p.stale(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasQueued reports whether p has queued tasks that may be started.
+func (p *WorkerPool) hasQueued() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused == nil && p.queued.next != &p.queued
+}
+
+// take removes and returns the first queued task that may run on w,
+// or nil if there is none.
+// others are the workers outside of p that could also run p's tasks.
+func (p *WorkerPool) take(w *Worker, others []*Worker) *Task {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused != nil {
+		return nil
+	}
+	alive := append(aliveWorkers(p.pool, p.maxWorkerFailures), others...)
+	for n := p.queued.next; n != &p.queued; n = n.next {
+		if p.stale(n) || p.cached(n) || p.exhausted(n, alive) {
+			continue
+		}
+		if !p.avoids(n.t, w, alive) {
+			listRemove(n)
+			p.running[n.t] = true
+			return n.t
+		}
+	}
+	return nil
+}
+
+// stale removes n from the queue if its task cannot be run anymore.
+//
+// Make sure that p.mu is held before calling this method!
+func (p *WorkerPool) stale(n *taskNode) bool {
+	if st := n.t.Status(); st.IsRunning() || st.Killed {
+		listRemove(n)
+		if st.Killed {
+			delete(p.failedOn, n.t)
+		}
+		return true
+	}
+	return false
+}
+
+// ErrNoWorkerLeft is the error of a task that has failed on every worker
+// it could run on while strict retries are enabled.
+var ErrNoWorkerLeft = errors.New("failed on every worker")
+
+// exhausted fails the task of n and removes n from the queue
+// if strict retries keep the task off of every one of alive.
+// Tasks wait as usual if there are no alive workers.
+//
+// Make sure that p.mu is held before calling this method!
+func (p *WorkerPool) exhausted(n *taskNode, alive []*Worker) bool {
+	if !p.strictRetries || len(alive) == 0 {
+		return false
+	}
+	failed := p.failedOn[n.t]
+	for _, w := range alive {
+		if !failed[w] {
+			return false
+		}
+	}
+	listRemove(n)
+	p.running[n.t] = true
+	st := n.t.Status()
+	st.Done = true
+	st.Err = ErrNoWorkerLeft
+	n.t.setStatus(st)
+	p.log.Errorf("task %s failed on all %d workers, not retrying it", n.t.Name, len(alive))
+	go p.finished(n.t)
+	return true
+}
+
+// avoids reports whether t should not be run on w.
+// A task is kept off of workers that it has failed on
+// unless strict retries are disabled and none of alive are left to try.
+//
+// Make sure that p.mu is held before calling this method!
+func (p *WorkerPool) avoids(t *Task, w *Worker, alive []*Worker) bool {
+	failed := p.failedOn[t]
+	if !failed[w] {
+		return false
+	}
+	if p.strictRetries {
+		return true
+	}
+	for _, x := range alive {
+		if !failed[x] {
+			return true
+		}
+	}
+	return false
+}
+
+// freeFor returns the index of the free worker that t should run on,
+// or -1 if t should wait.
+//
+// Make sure that p.mu is held before calling this method!
+func (p *WorkerPool) freeFor(t *Task, alive []*Worker) int {
+	for i := len(p.free) - 1; i >= 0; i-- {
+		if !p.avoids(t, p.free[i], alive) {
+			return i
+		}
+	}
+	return -1
+}
+
+func aliveWorkers(ws []*Worker, maxFailures int) []*Worker {
+	var alive []*Worker
+	for _, w := range ws {
+		if wst := w.Status(); !wst.Killed && !wst.Draining && wst.FailedTasks < maxFailures {
+			alive = append(alive, w)
+		}
+	}
+	return alive
+}
+
 // schedule schedules currently queued tasks on workers.
 //
 // Make sure that p.mu is held before calling this method!
 func (p *WorkerPool) schedule() {
 	p.log.Infof("scheduler: has tasks: %t nworkers: %d", p.queued.next != &p.queued, len(p.free))
-	for p.queued.next != &p.queued && len(p.free) != 0 {
-		t := p.queued.next.t
-		p.queued.next = p.queued.next.next
-		p.queued.prev = &p.queued
-		if t.Status().IsRunning() || t.Status().Killed {
+	dropDraining(&p.free)
+	p.rollInit()
+	if p.paused != nil {
+		return
+	}
+	p.checkCache()
+	alive := aliveWorkers(p.pool, p.maxWorkerFailures)
+	for n := p.queued.next; n != &p.queued && len(p.free) != 0; n = n.next {
+		// Shared workers may still be left for the task,
+		// which SharedPool.take checks for.
+		if p.stale(n) || (p.shared == nil && p.exhausted(n, alive)) {
+			continue
+		}
+		i := p.freeFor(n.t, alive)
+		if i < 0 {
 			continue
 		}
-		w := p.free[len(p.free)-1]
-		p.free = p.free[:len(p.free)-1]
+		listRemove(n)
+		p.running[n.t] = true
+		w := p.free[i]
+		p.free = append(p.free[:i], p.free[i+1:]...)
 		go func(w *Worker, t *Task) {
 			w.Run(t)
+			p.keepResult(t)
 			p.mu.Lock()
 			addFree(&p.free, []*Worker{w}, p.maxWorkerFailures)
 			p.schedule()
 			p.mu.Unlock()
-			st := t.Status()
-			if st.Err != nil && st.Tries < p.maxTaskTries && !st.Killed {
-				if st.Err != errWorkerKilled {
-					t.Kill(context.Background(), false)
-				}
-				p.Submit(t)
-			}
-		}(w, t)
+			p.finished(t)
+		}(w, n.t)
 	}
 }
 
+// keepResult collects the artifacts of the attempt of t that just ran
+// and adds it to the cache.
+// It is called before the worker is freed so that the next task
+// can be completed from the cache.
+func (p *WorkerPool) keepResult(t *Task) {
+	p.collectArtifacts(t)
+	p.addToCache(t)
+}
+
+// finished handles the end of an attempt of t.
+// It records the worker that t failed on,
+// resubmits t if the attempt failed and t has tries left,
+// and sends the resulting events.
+func (p *WorkerPool) finished(t *Task) {
+	st := t.Status()
+	p.mu.Lock()
+	if st.Err != nil && st.Err != errWorkerKilled && st.Err != errTaskKilled && !IsPermanent(st.Err) && st.Runner != nil {
+		if p.failedOn[t] == nil {
+			p.failedOn[t] = make(map[*Worker]bool)
+		}
+		p.failedOn[t][st.Runner] = true
+	}
+	var kind EventKind
+	switch {
+	case st.Err == nil:
+		kind = TaskSucceeded
+	case st.Tries < p.maxTaskTries && !st.Killed && !IsPermanent(st.Err):
+		kind = TaskRetrying
+	case !st.Killed && st.Err != errTaskKilled:
+		kind = TaskFailed
+	}
+	if kind != TaskRetrying {
+		delete(p.failedOn, t)
+		delete(p.running, t)
+	}
+	onEvent := p.onEvent
+	p.mu.Unlock()
+
+	now := time.Now()
+	if onEvent != nil && kind != "" {
+		onEvent(Event{Kind: kind, Time: now, Task: t, Status: st})
+	}
+
+	if kind == TaskRetrying {
+		if st.Err != errWorkerKilled {
+			t.killSession(context.Background())
+		}
+		p.resubmit(t)
+		return
+	}
+
+	if onEvent == nil {
+		return
+	}
+	p.mu.Lock()
+	empty := !p.emptySent && p.queueEmpty()
+	if empty {
+		p.emptySent = true
+	}
+	p.mu.Unlock()
+	if empty {
+		onEvent(Event{Kind: QueueEmpty, Time: now, Task: t, Status: st})
+	}
+}
+
+// Forget drops what p remembers about t.
+// It should be called when t is removed.
+func (p *WorkerPool) Forget(t *Task) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.failedOn, t)
+}
+
+// FailedOn returns the workers that t has failed on
+// while it is being retried.
+func (p *WorkerPool) FailedOn(t *Task) []*Worker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ws []*Worker
+	for w := range p.failedOn[t] {
+		ws = append(ws, w)
+	}
+	sort.Slice(ws, func(i, j int) bool {
+		return ws[i].Name() < ws[j].Name()
+	})
+	return ws
+}
+
+// SetStrictRetries controls whether a task may be retried on a worker it
+// has failed on when no other worker is left to try.
+// With strict retries, a task that has failed on every worker fails with ErrNoWorkerLeft.
+func (p *WorkerPool) SetStrictRetries(strict bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.strictRetries = strict
+}
+
+func (p *WorkerPool) StrictRetries() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.strictRetries
+}
+
 type Task struct {
 	Name string   `json:"name"`
 	Cmd  []string `json:"cmd"`
 	Env  []string `json:"env"`
 	WD   string   `json:"wd"`
 
-	mu     sync.Mutex
-	status TaskStatus
+	// Outputs are globs, relative to WD, of files to keep from each attempt.
+	Outputs []string `json:"outputs"`
+
+	Cache *CacheSpec `json:"cache"`
+
+	// Exit classifies the exits of Cmd.
+	// If nil, the policy of the task's pool is used.
+	Exit *ExitPolicy `json:"exit"`
+
+	// Labels are used to select tasks and do not affect how they run.
+	Labels map[string]string `json:"labels"`
+
+	mu       sync.Mutex
+	status   TaskStatus
+	defaults taskDefaults
+	pgid     int // of the running attempt, once known
+
+	cacheChecked bool // since the task was last rerun
 }
 
 func (t *Task) FreshCopy() *Task {
 	return &Task{
-		Name: t.Name,
-		Cmd:  t.Cmd,
-		Env:  t.Env,
-		WD:   t.WD,
+		Name:    t.Name,
+		Cmd:     t.Cmd,
+		Env:     t.Env,
+		WD:      t.WD,
+		Outputs: t.Outputs,
+		Cache:   t.Cache,
+		Exit:    t.Exit,
+		Labels:  t.Labels,
+	}
+}
+
+func (t *Task) exitPolicy() *ExitPolicy {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Exit != nil {
+		return t.Exit
 	}
+	return t.defaults.exit
 }
 
 func (t *Task) Status() TaskStatus {
@@ -513,6 +1211,16 @@ func (t *Task) setStatus(s TaskStatus) {
 	t.mu.Unlock()
 }
 
+// resetForRerun clears the state of t's last run, keeping its attempts.
+func (t *Task) resetForRerun() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status = TaskStatus{
+		Attempts: t.status.Attempts,
+	}
+	t.cacheChecked = false
+}
+
 func (t *Task) ResetTries() {
 	t.mu.Lock()
 	t.status.Tries = 0
@@ -522,10 +1230,7 @@ func (t *Task) ResetTries() {
 func (t *Task) Kill(ctx context.Context, workerKilled bool) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	session := t.status.Tmux.Session
-	if session != "" {
-		exec.CommandContext(ctx, "tmux", "kill-session", "-t", session).Run()
-	}
+	t.killSessionLocked(ctx)
 	if workerKilled {
 		t.status.Err = errWorkerKilled
 		t.status.Killed = false
@@ -535,12 +1240,47 @@ func (t *Task) Kill(ctx context.Context, workerKilled bool) {
 	}
 }
 
+// killSession kills the tmux session of t's last attempt
+// without marking t as killed.
+func (t *Task) killSession(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.killSessionLocked(ctx)
+}
+
+func (t *Task) killSessionLocked(ctx context.Context) {
+	session := t.status.Tmux.Session
+	if session != "" {
+		exec.CommandContext(ctx, "tmux", "kill-session", "-t", session).Run()
+	}
+	if t.status.Suspended && t.pgid != 0 {
+		// Let the stopped processes handle the hangup.
+		syscall.Kill(-t.pgid, syscall.SIGCONT)
+	}
+}
+
 type TaskStatus struct {
-	Done   bool
-	Killed bool
-	Err    error
-	Tries  int
-	Runner *Worker
+	Done     bool
+	Killed   bool
+	Err      error
+	Tries    int
+	Runner   *Worker
+	Attempts []Attempt
+
+	// CacheKey is set for tasks with a CacheSpec once they are looked up in the cache.
+	CacheKey string
+	// Cached is set if the task reused the result of CachedFrom,
+	// a TASK/ATTEMPT of an earlier task, instead of running.
+	Cached       bool
+	CachedFrom   string
+	CachedOutput string
+
+	// Suspended is set while the running attempt is stopped by Suspend.
+	// SuspendedFor is the total time the attempt has been suspended,
+	// not counting the current suspension.
+	Suspended    bool
+	SuspendedAt  time.Time
+	SuspendedFor time.Duration
 
 	Tmux struct {
 		Session string
@@ -552,6 +1292,9 @@ func (s TaskStatus) IsNew() bool {
 }
 
 func (s TaskStatus) GetOutput() (string, error) {
+	if s.Cached {
+		return s.CachedOutput, nil
+	}
 	if s.Tmux.Session == "" {
 		return "", nil
 	}
@@ -560,11 +1303,46 @@ func (s TaskStatus) GetOutput() (string, error) {
 	return string(b), err
 }
 
+// SessionAlive reports whether the tmux session of the task's last attempt still exists.
+func (s TaskStatus) SessionAlive() bool {
+	if s.Tmux.Session == "" {
+		return false
+	}
+	return exec.Command("tmux", "has-session", "-t", "="+s.Tmux.Session).Run() == nil
+}
+
+// LastAttempt returns the most recent finished attempt, or nil if there is none.
+func (s TaskStatus) LastAttempt() *Attempt {
+	if len(s.Attempts) == 0 {
+		return nil
+	}
+	a := s.Attempts[len(s.Attempts)-1]
+	return &a
+}
+
+// ExitCode returns the shell status of the command of the last attempt,
+// or -1 if there is no attempt or the command did not exit.
+func (s TaskStatus) ExitCode() int {
+	if a := s.LastAttempt(); a != nil {
+		return a.ExitCode
+	}
+	return -1
+}
+
+// FailedPermanently reports whether the last attempt failed in a way
+// that will not be retried.
+func (s TaskStatus) FailedPermanently() bool {
+	return s.Done && IsPermanent(s.Err)
+}
+
 func (s TaskStatus) IsRunning() bool {
 	return s.Err == nil && !s.Killed && !s.Done && s.Runner != nil
 }
 
 func (s TaskStatus) HumanFriendly(maxTries int) string {
+	if s.Cached {
+		return "Cached from " + s.CachedFrom
+	}
 	if s.Done {
 		if s.Err == nil {
 			return "Ran on " + s.Runner.Name()
@@ -573,6 +1351,9 @@ func (s TaskStatus) HumanFriendly(maxTries int) string {
 		}
 	}
 	if s.Runner != nil {
+		if s.Suspended {
+			return "Suspended on " + s.Runner.Name()
+		}
 		return "Running on " + s.Runner.Name()
 	}
 	if s.Tries > maxTries {
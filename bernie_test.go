@@ -0,0 +1,124 @@
+package bernie
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// newTestPool returns a pool with a worker that runs tasks in tmux.
+// It skips the test if tmux is not installed.
+func newTestPool(t *testing.T, maxFailures, maxTries int) (*WorkerPool, *Worker, string) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux is not installed")
+	}
+	dir, err := ioutil.TempDir("", "bernie-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewWorkerPool(nopLogger{}, maxFailures, maxTries, &Task{Cmd: []string{"true"}, WD: dir})
+	w := NewWorker(nopLogger{}, "w", "")
+	p.Grow([]*Worker{w})
+	return p, w, dir
+}
+
+// cleanup kills the tmux sessions of ts and w's init task and removes dir.
+func cleanup(dir string, w *Worker, ts ...*Task) {
+	if it := w.Status().InitTask; it != nil {
+		ts = append(ts, it)
+	}
+	for _, t := range ts {
+		t.killSession(context.Background())
+	}
+	os.RemoveAll(dir)
+}
+
+// waitFinished waits for t to have finished n attempts and not be queued again.
+func waitFinished(t *testing.T, p *WorkerPool, task *Task, n int) TaskStatus {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		p.mu.Lock()
+		busy := p.running[task] || p.isQueued(task)
+		p.mu.Unlock()
+		if st := task.Status(); !busy && st.Done && len(st.Attempts) >= n {
+			return st
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("task %s did not finish %d attempts: %+v", task.Name, n, task.Status())
+	return TaskStatus{}
+}
+
+func TestRerunCached(t *testing.T) {
+	p, w, dir := newTestPool(t, 3, 1)
+	task := &Task{Name: "a", Cmd: []string{"true"}, WD: dir, Cache: &CacheSpec{}}
+	defer cleanup(dir, w, task)
+
+	if err := p.Submit(task); err != nil {
+		t.Fatal(err)
+	}
+	st := waitFinished(t, p, task, 1)
+	if st.Err != nil || st.Cached {
+		t.Fatalf("first run: err %v, cached %t, want a successful run", st.Err, st.Cached)
+	}
+
+	if err := p.Rerun(task); err != nil {
+		t.Fatalf("Rerun: %v", err)
+	}
+	st = waitFinished(t, p, task, 2)
+	if len(st.Attempts) != 2 {
+		t.Fatalf("got %d attempts, want 2", len(st.Attempts))
+	}
+	if a := st.Attempts[1]; st.Cached || a.CachedFrom != "" || a.Worker != w.Name() {
+		t.Errorf("rerun was cached from %q, want it to run on %s", a.CachedFrom, w.Name())
+	}
+}
+
+func TestWorkerFailureLimit(t *testing.T) {
+	p, w, dir := newTestPool(t, 1, 1)
+	fail := &Task{Name: "fail", Cmd: []string{"false"}, WD: dir}
+	next := &Task{Name: "next", Cmd: []string{"true"}, WD: dir}
+	defer cleanup(dir, w, fail, next)
+
+	p.Submit(fail)
+	waitFinished(t, p, fail, 1)
+	if n := w.Status().FailedTasks; n != 1 {
+		t.Fatalf("worker failed %d tasks, want 1", n)
+	}
+
+	p.Submit(next)
+	time.Sleep(300 * time.Millisecond)
+	if st := next.Status(); st.Tries != 0 {
+		t.Fatalf("task ran on a worker that reached its failure limit")
+	}
+
+	p.ResetFailures(w)
+	if st := waitFinished(t, p, next, 1); st.Attempts[0].Worker != w.Name() {
+		t.Errorf("task ran on %q after the worker's failures were reset, want %s", st.Attempts[0].Worker, w.Name())
+	}
+}
+
+func TestLowerWorkerFailureLimit(t *testing.T) {
+	p, w, dir := newTestPool(t, 3, 1)
+	fail := &Task{Name: "fail", Cmd: []string{"false"}, WD: dir}
+	next := &Task{Name: "next", Cmd: []string{"true"}, WD: dir}
+	defer cleanup(dir, w, fail, next)
+
+	p.Submit(fail)
+	waitFinished(t, p, fail, 1)
+
+	p.SetAllowableWorkerFailures(1)
+	p.Submit(next)
+	time.Sleep(300 * time.Millisecond)
+	if st := next.Status(); st.Tries != 0 {
+		t.Fatalf("task ran on an idle worker past the lowered failure limit")
+	}
+
+	p.SetAllowableWorkerFailures(2)
+	if st := waitFinished(t, p, next, 1); st.Attempts[0].Worker != w.Name() {
+		t.Errorf("task ran on %q after the failure limit was raised, want %s", st.Attempts[0].Worker, w.Name())
+	}
+}
@@ -0,0 +1,284 @@
+package bernie
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheSpec opts a task into result caching.
+//
+// A task's cache key covers its Cmd, the Env entries named in Env,
+// the definition of its group's init task, and the contents of the files
+// under WD matched by Inputs.
+// If a task with the same key has already succeeded, the task is not run
+// and reuses that task's output and artifacts instead.
+type CacheSpec struct {
+	Env    []string `json:"env"`
+	Inputs []string `json:"inputs"`
+}
+
+type cacheEntry struct {
+	task        string
+	attempt     int
+	exitCode    int
+	output      string
+	artifactDir string
+}
+
+// cacheKey computes the cache key of t, which runs after init.
+func cacheKey(t *Task, init *Task) (string, error) {
+	h := sha256.New()
+	writeStrings(h, "cmd", t.Cmd)
+	defined := t.definedEnv()
+	env := make([]string, len(t.Cache.Env))
+	for i, k := range t.Cache.Env {
+		env[i] = k + " unset"
+		for _, kv := range defined {
+			if strings.HasPrefix(kv, k+"=") {
+				env[i] = kv
+			}
+		}
+	}
+	writeStrings(h, "env", env)
+	if init != nil {
+		writeStrings(h, "init.cmd", init.Cmd)
+		writeStrings(h, "init.env", init.definedEnv())
+		writeStrings(h, "init.wd", []string{init.WD})
+	}
+	files, err := inputFiles(t.WD, t.Cache.Inputs)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		writeStrings(h, "input", []string{f})
+		if err := hashFile(h, filepath.Join(t.WD, f)); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func writeStrings(h hash.Hash, name string, ss []string) {
+	fmt.Fprintf(h, "%s %d\n", name, len(ss))
+	for _, s := range ss {
+		fmt.Fprintf(h, "%d %s\n", len(s), s)
+	}
+}
+
+func hashFile(h hash.Hash, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fh := sha256.New()
+	if _, err := io.Copy(fh, f); err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "%x\n", fh.Sum(nil))
+	return nil
+}
+
+// inputFiles returns the sorted paths, relative to wd, of the regular files
+// matched by globs.
+func inputFiles(wd string, globs []string) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, g := range globs {
+		matches, err := filepath.Glob(filepath.Join(wd, g))
+		if err != nil {
+			return nil, fmt.Errorf("bad input pattern %q: %v", g, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("input %q matches no files", g)
+		}
+		for _, m := range matches {
+			err := filepath.Walk(m, func(path string, info os.FileInfo, err error) error {
+				if err != nil || !info.Mode().IsRegular() {
+					return err
+				}
+				rel, err := filepath.Rel(wd, path)
+				if err != nil {
+					return err
+				}
+				seen[rel] = true
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	files := make([]string, 0, len(seen))
+	for f := range seen {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// checkCache takes the queued tasks with a CacheSpec that have not been
+// looked up in the cache off of the queue and looks them up in the background.
+// Tasks found in the cache are completed without taking up a worker
+// and the others are put back at the front of the queue.
+//
+// Make sure that p.mu is held before calling this method!
+func (p *WorkerPool) checkCache() {
+	for n := p.queued.next; n != &p.queued; n = n.next {
+		if n.t.Cache == nil || p.stale(n) || p.cached(n) || n.t.isCacheChecked() {
+			continue
+		}
+		listRemove(n)
+		p.running[n.t] = true
+		go func(t *Task) {
+			if p.fromCache(t) {
+				p.finished(t)
+				return
+			}
+			p.requeue(t)
+			p.mu.Lock()
+			shared := p.shared
+			p.mu.Unlock()
+			if shared != nil {
+				shared.schedule()
+			}
+		}(n.t)
+	}
+}
+
+// cached removes n from the queue and completes its task in the background
+// if the task has been looked up in the cache and a task with the same key
+// has since succeeded.
+//
+// Make sure that p.mu is held before calling this method!
+func (p *WorkerPool) cached(n *taskNode) bool {
+	key := n.t.Status().CacheKey
+	e, ok := p.cache[key]
+	if key == "" || !ok {
+		return false
+	}
+	listRemove(n)
+	p.running[n.t] = true
+	go func(t *Task) {
+		p.useCache(t, key, e)
+		p.finished(t)
+	}(n.t)
+	return true
+}
+
+func (t *Task) isCacheChecked() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cacheChecked
+}
+
+// fromCache completes t from the cache if a task with the same key has
+// already succeeded.
+// It reports whether t was completed.
+func (p *WorkerPool) fromCache(t *Task) bool {
+	t.mu.Lock()
+	t.cacheChecked = true
+	t.mu.Unlock()
+	key, err := cacheKey(t, p.freshInit())
+	if err != nil {
+		p.log.Errorf("unable to compute cache key of %s, running it: %v", t.Name, err)
+		return false
+	}
+
+	p.mu.Lock()
+	e, ok := p.cache[key]
+	p.mu.Unlock()
+
+	if !ok {
+		st := t.Status()
+		st.CacheKey = key
+		t.setStatus(st)
+		return false
+	}
+	p.useCache(t, key, e)
+	return true
+}
+
+// useCache completes t with the result e that is cached under key.
+func (p *WorkerPool) useCache(t *Task, key string, e *cacheEntry) {
+	st := t.Status()
+	now := time.Now()
+	st.CacheKey = key
+	st.Done = true
+	st.Err = nil
+	st.Cached = true
+	st.CachedFrom = e.task + "/" + strconv.Itoa(e.attempt)
+	st.CachedOutput = e.output
+	st.Attempts = append(st.Attempts[:len(st.Attempts):len(st.Attempts)], Attempt{
+		Start:      now,
+		End:        now,
+		ExitCode:   e.exitCode,
+		Signal:     exitSignal(e.exitCode),
+		CachedFrom: st.CachedFrom,
+	})
+	t.setStatus(st)
+
+	if e.artifactDir != "" {
+		dst := p.AttemptArtifactDir(t, len(st.Attempts))
+		if dst != "" {
+			if err := linkTree(e.artifactDir, dst); err != nil {
+				p.log.Errorf("unable to link cached artifacts of %s: %v", t.Name, err)
+			}
+		}
+	}
+	p.log.Infof("task %s cached from %s", t.Name, st.CachedFrom)
+}
+
+// addToCache records the last attempt of t in the cache if it succeeded.
+func (p *WorkerPool) addToCache(t *Task) {
+	st := t.Status()
+	if st.CacheKey == "" || st.Err != nil || st.Cached {
+		return
+	}
+	out, err := st.GetOutput()
+	if err != nil {
+		p.log.Errorf("unable to record output of %s for cache: %v", t.Name, err)
+	}
+	e := &cacheEntry{
+		task:     t.Name,
+		attempt:  len(st.Attempts),
+		exitCode: st.ExitCode(),
+		output:   out,
+	}
+	if len(t.Outputs) > 0 {
+		e.artifactDir = p.AttemptArtifactDir(t, e.attempt)
+	}
+	p.mu.Lock()
+	p.cache[st.CacheKey] = e
+	p.mu.Unlock()
+}
+
+// linkTree hard links the files under src into dst, copying them if
+// they cannot be linked.
+func linkTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if os.Link(path, target) == nil {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
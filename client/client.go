@@ -0,0 +1,273 @@
+// Package client talks to a bernie server over the v1 routes of its HTTP API.
+//
+// Methods that operate on workers take a group name,
+// or Shared to operate on the shared worker pool instead.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Shared names the shared worker pool in methods that take a group.
+const Shared = ""
+
+// apiPrefix is the path of the API version the client uses, relative to the server.
+const apiPrefix = "v1/"
+
+// Client is a client of one bernie server.
+// Its fields must not be changed once it is in use.
+type Client struct {
+	// BaseURL is the address of the server.
+	BaseURL *url.URL
+	// HTTPClient sends requests, or http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Token, if set, is sent as a bearer token,
+	// for proxies in front of the server.
+	Token string
+}
+
+// New returns a client of the server at addr, such as http://127.0.0.1:8080.
+func New(addr string) (*Client, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid addr: %v", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid addr %q: need scheme and host", addr)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return &Client{BaseURL: u}, nil
+}
+
+// Error codes the server gives in an Error.
+const (
+	CodeInvalid  = "invalid_request"
+	CodeNotFound = "not_found"
+	CodeExists   = "already_exists"
+	// CodeConflict is given when the state of an object does not allow an operation,
+	// such as suspending a task that is not running.
+	CodeConflict = "conflict"
+	CodeInternal = "internal"
+)
+
+// Error is returned when the server rejects a request.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Status is the HTTP status line, such as "404 Not Found".
+	Status string
+	// Code is one of the Code constants, or empty if the server did not give one.
+	Code string
+	// Message is the server's explanation, if it gave one.
+	Message string
+	// Details is a JSON object with more about the error, if the server gave one.
+	Details json.RawMessage
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Status
+	}
+	return e.Status + ": " + e.Message
+}
+
+// IsNotFound reports whether err is an Error for an object that does not exist.
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// responseError returns the error that the body b of resp describes.
+func responseError(resp *http.Response, b []byte) *Error {
+	var body struct {
+		Error struct {
+			Code    string          `json:"code"`
+			Message string          `json:"message"`
+			Details json.RawMessage `json:"details"`
+		} `json:"error"`
+	}
+	e := &Error{StatusCode: resp.StatusCode, Status: resp.Status}
+	if err := json.Unmarshal(b, &body); err != nil || body.Error.Code == "" {
+		// Not from bernie, such as from a proxy.
+		e.Message = string(bytes.TrimSpace(b))
+		return e
+	}
+	e.Code = body.Error.Code
+	e.Message = body.Error.Message
+	e.Details = body.Error.Details
+	return e
+}
+
+// path joins elems, escaping each one, into a path relative to the server.
+func path(elems ...string) string {
+	esc := make([]string, len(elems))
+	for i, e := range elems {
+		esc[i] = url.PathEscape(e)
+	}
+	return strings.Join(esc, "/")
+}
+
+// escapeSlashed escapes each element of the slash-separated path p.
+func escapeSlashed(p string) string {
+	return path(strings.Split(p, "/")...)
+}
+
+// workersPath returns the path of the workers of group.
+func workersPath(group string) string {
+	if group == Shared {
+		return "shared/workers"
+	}
+	return path("workers", group)
+}
+
+// open sends a request with method to the server at rel and returns the response.
+// If req is not nil, it is sent as JSON.
+// The caller must close the response body.
+func (c *Client) open(ctx context.Context, method, rel string, req interface{}) (*http.Response, error) {
+	u, err := c.BaseURL.Parse(apiPrefix + rel)
+	if err != nil {
+		return nil, fmt.Errorf("unable to construct request url: %v", err)
+	}
+	var body io.Reader
+	if req != nil {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode request: %v", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	hreq, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s request: %v", method, err)
+	}
+	if body != nil {
+		hreq.Header.Set("content-type", "application/json")
+	}
+	if c.Token != "" {
+		hreq.Header.Set("authorization", "Bearer "+c.Token)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(hreq)
+	if err != nil {
+		return nil, fmt.Errorf("unable to issue %s request: %v", method, err)
+	}
+	return resp, nil
+}
+
+// call is like open but reads the response body.
+// It returns an *Error if the server did not accept the request.
+func (c *Client) call(ctx context.Context, method, rel string, req interface{}) ([]byte, error) {
+	resp, err := c.open(ctx, method, rel, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading body: %v", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, responseError(resp, b)
+	}
+	return b, nil
+}
+
+// do sends req with method to rel and decodes the response into resp if it is not nil.
+func (c *Client) do(ctx context.Context, method, rel string, req, resp interface{}) error {
+	b, err := c.call(ctx, method, rel, req)
+	if err != nil {
+		return err
+	}
+	return decode(b, resp)
+}
+
+// text gets the plain text at rel.
+func (c *Client) text(ctx context.Context, rel string) (string, error) {
+	rc, err := c.stream(ctx, rel)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return "", fmt.Errorf("error while reading body: %v", err)
+	}
+	return string(b), nil
+}
+
+// stream gets the body at rel for the caller to read and close.
+func (c *Client) stream(ctx context.Context, rel string) (io.ReadCloser, error) {
+	resp, err := c.open(ctx, "GET", rel, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, responseError(resp, b)
+	}
+	return resp.Body, nil
+}
+
+func decode(b []byte, resp interface{}) error {
+	if resp == nil {
+		return nil
+	}
+	if err := json.Unmarshal(b, resp); err != nil {
+		return fmt.Errorf("unable to decode response: %v", err)
+	}
+	return nil
+}
+
+// ListOptions filters and pages listings.
+type ListOptions struct {
+	// Prefix, if set, limits the listing to objects whose names start with it.
+	Prefix string
+	// States, if set, limits the listing to objects in one of them.
+	States []string
+	// Limit is the most objects to return, or the server's default if 0.
+	Limit int
+	// Cursor continues the listing from the Next of an earlier page.
+	Cursor string
+}
+
+func (o *ListOptions) query() string {
+	if o == nil {
+		return ""
+	}
+	q := make(url.Values)
+	if o.Prefix != "" {
+		q.Set("prefix", o.Prefix)
+	}
+	if len(o.States) > 0 {
+		q.Set("state", strings.Join(o.States, ","))
+	}
+	if o.Limit > 0 {
+		q.Set("limit", fmt.Sprint(o.Limit))
+	}
+	if o.Cursor != "" {
+		q.Set("cursor", o.Cursor)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// Metrics returns the server's metrics in the Prometheus text format.
+func (c *Client) Metrics(ctx context.Context) (string, error) {
+	return c.text(ctx, "metrics")
+}
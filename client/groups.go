@@ -0,0 +1,250 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// ExitPolicy classifies the exits of a command by exit code or signal name,
+// such as "0", "3", or "SIGKILL".
+type ExitPolicy struct {
+	Success   []string `json:"success,omitempty"`
+	Retryable []string `json:"retryable,omitempty"`
+	Permanent []string `json:"permanent,omitempty"`
+}
+
+// NewGroup describes a group to create.
+type NewGroup struct {
+	Name string `json:"name"`
+	Init Task   `json:"init"`
+	// If Share is positive, the group is also served by the shared workers
+	// with Share as its weight.
+	Share float64 `json:"share,omitempty"`
+	// If StrictRetries is set, failed tasks never go back to a worker they failed on.
+	StrictRetries bool `json:"strictretries,omitempty"`
+	// Exit is the exit policy of tasks that do not have their own.
+	Exit *ExitPolicy `json:"exit,omitempty"`
+	// Env holds KEY=VALUE entries given to every task and the init task.
+	Env []string `json:"env,omitempty"`
+	// Secrets are like Env but never shown by the server.
+	Secrets map[string]string `json:"secrets,omitempty"`
+	// ManifestSchema maps keys that worker manifests, as JSON objects, must have
+	// to their types: string, number, bool, object, or array.
+	ManifestSchema map[string]string `json:"manifestschema,omitempty"`
+	// MaxTries and MaxFails override the server's defaults if positive.
+	MaxTries int `json:"maxtries,omitempty"`
+	MaxFails int `json:"maxfails,omitempty"`
+}
+
+// Pause tells whether a group is paused, and if so, by whom and why.
+type Pause struct {
+	Paused bool      `json:"paused"`
+	By     string    `json:"by,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
+}
+
+// Group describes a group.
+type Group struct {
+	Name           string            `json:"name"`
+	Share          float64           `json:"share"`
+	StrictRetries  bool              `json:"strictretries"`
+	MaxTries       int               `json:"maxtries"`
+	MaxFails       int               `json:"maxfails"`
+	InitVersion    int               `json:"initversion"`
+	Paused         Pause             `json:"paused"`
+	Env            []string          `json:"env"`
+	Secrets        []string          `json:"secrets"`
+	ManifestSchema map[string]string `json:"manifestschema,omitempty"`
+	// Tasks counts the tasks of the group by state.
+	Tasks   map[string]int `json:"tasks"`
+	Workers int            `json:"workers"`
+}
+
+// GroupPage is a page of a group listing.
+type GroupPage struct {
+	Groups []Group `json:"groups"`
+	// Next is the cursor of the next page, or empty if this is the last.
+	Next string `json:"next,omitempty"`
+}
+
+// ListGroups lists a page of groups.
+// Group states are active and paused.
+func (c *Client) ListGroups(ctx context.Context, opts *ListOptions) (*GroupPage, error) {
+	var page GroupPage
+	if err := c.do(ctx, "GET", "groups"+opts.query(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// AllGroups lists every group, following the cursors of ListGroups.
+func (c *Client) AllGroups(ctx context.Context, opts *ListOptions) ([]Group, error) {
+	o := listOptions(opts)
+	all := []Group{}
+	for {
+		page, err := c.ListGroups(ctx, &o)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Groups...)
+		if page.Next == "" {
+			return all, nil
+		}
+		o.Cursor = page.Next
+	}
+}
+
+// listOptions returns a copy of opts that can be changed to page through a listing.
+func listOptions(opts *ListOptions) ListOptions {
+	if opts == nil {
+		return ListOptions{}
+	}
+	return *opts
+}
+
+// AddGroup creates a group.
+func (c *Client) AddGroup(ctx context.Context, g *NewGroup) error {
+	return c.do(ctx, "POST", "groups", g, nil)
+}
+
+// Group describes the named group.
+func (c *Client) Group(ctx context.Context, name string) (*Group, error) {
+	var g Group
+	if err := c.do(ctx, "GET", path("groups", name), nil, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// RemoveGroup kills the tasks of the named group, removes its workers, and removes it.
+func (c *Client) RemoveGroup(ctx context.Context, name string) error {
+	return c.do(ctx, "DELETE", path("groups", name), nil, nil)
+}
+
+// GroupLimits holds the limits of a group that can be changed.
+// Fields that are 0 are left unchanged.
+type GroupLimits struct {
+	MaxTries int `json:"maxtries,omitempty"`
+	MaxFails int `json:"maxfails,omitempty"`
+}
+
+// SetGroupLimits changes the limits of the named group.
+func (c *Client) SetGroupLimits(ctx context.Context, name string, l GroupLimits) error {
+	return c.do(ctx, "PATCH", path("groups", name), &l, nil)
+}
+
+type setInitReq struct {
+	Init      *Task `json:"init"`
+	MaxReinit int   `json:"maxreinit"`
+}
+
+// SetInitTask replaces the init task of group and returns its new version.
+// Workers are re-initialized as they become idle, at most maxReinit at a time.
+func (c *Client) SetInitTask(ctx context.Context, group string, init *Task, maxReinit int) (int, error) {
+	var resp struct {
+		Version int `json:"version"`
+	}
+	err := c.do(ctx, "POST", path("groups", group, "init"), &setInitReq{init, maxReinit}, &resp)
+	return resp.Version, err
+}
+
+type pauseReq struct {
+	By     string `json:"by"`
+	Reason string `json:"reason"`
+}
+
+// PauseGroup stops group from starting queued tasks.
+// If by is empty, the server records the client's address.
+func (c *Client) PauseGroup(ctx context.Context, group, by, reason string) error {
+	return c.do(ctx, "POST", path("groups", group, "pause"), &pauseReq{by, reason}, nil)
+}
+
+// ResumeGroup lets a paused group start queued tasks.
+func (c *Client) ResumeGroup(ctx context.Context, group string) error {
+	return c.do(ctx, "POST", path("groups", group, "resume"), struct{}{}, nil)
+}
+
+// GroupPause tells whether group is paused.
+func (c *Client) GroupPause(ctx context.Context, group string) (*Pause, error) {
+	var p Pause
+	if err := c.do(ctx, "GET", path("groups", group, "pause"), nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+type secretsReq struct {
+	Secrets map[string]string `json:"secrets"`
+}
+
+// SetSecrets adds or replaces secrets of group.
+func (c *Client) SetSecrets(ctx context.Context, group string, secrets map[string]string) error {
+	return c.do(ctx, "POST", path("groups", group, "secrets"), &secretsReq{secrets}, nil)
+}
+
+// RemoveSecret removes the secret key of group.
+func (c *Client) RemoveSecret(ctx context.Context, group, key string) error {
+	return c.do(ctx, "DELETE", path("groups", group, "secrets", key), nil, nil)
+}
+
+// Event kinds that webhooks can be sent for.
+const (
+	TaskSucceeded = "task-succeeded"
+	TaskRetrying  = "task-retrying"
+	TaskFailed    = "task-failed"
+	QueueEmpty    = "queue-empty"
+)
+
+// Delivery records an attempt to send an event to a webhook.
+type Delivery struct {
+	Event string    `json:"event"`
+	Task  string    `json:"task,omitempty"`
+	Time  time.Time `json:"time"`
+	Tries int       `json:"tries"`
+	// Status is the HTTP status of the last try.
+	Status    int    `json:"status,omitempty"`
+	Err       string `json:"err,omitempty"`
+	Delivered bool   `json:"delivered"`
+}
+
+// Webhook describes a webhook of a group.
+type Webhook struct {
+	ID     int      `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Deliveries are the most recent deliveries, oldest first.
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+type webhookReq struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+}
+
+// AddWebhook makes the server POST the events of group to hookURL and returns the webhook's ID.
+// If no events are given, all are sent.
+func (c *Client) AddWebhook(ctx context.Context, group, hookURL string, events ...string) (int, error) {
+	var resp struct {
+		ID int `json:"id"`
+	}
+	err := c.do(ctx, "POST", path("groups", group, "webhooks"), &webhookReq{hookURL, events}, &resp)
+	return resp.ID, err
+}
+
+// Webhooks lists the webhooks of group.
+func (c *Client) Webhooks(ctx context.Context, group string) ([]Webhook, error) {
+	var resp struct {
+		Webhooks []Webhook `json:"webhooks"`
+	}
+	if err := c.do(ctx, "GET", path("groups", group, "webhooks"), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Webhooks, nil
+}
+
+// RemoveWebhook removes the webhook of group with id.
+func (c *Client) RemoveWebhook(ctx context.Context, group string, id int) error {
+	return c.do(ctx, "DELETE", path("groups", group, "webhooks", strconv.Itoa(id)), nil, nil)
+}
@@ -0,0 +1,386 @@
+package client
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"time"
+)
+
+// CacheSpec lists what, besides the command, makes up the cache key of a task.
+type CacheSpec struct {
+	// Env are names of variables in the task's environment.
+	Env []string `json:"env"`
+	// Inputs are globs, relative to the task's working directory, of input files.
+	Inputs []string `json:"inputs"`
+}
+
+// Task is a command to run.
+type Task struct {
+	Name string   `json:"name"`
+	Cmd  []string `json:"cmd"`
+	// Env holds KEY=VALUE entries.
+	// The server's environment is used for variables that are not set.
+	Env []string `json:"env"`
+	WD  string   `json:"wd"`
+	// Outputs are globs, relative to WD, of files to keep from each attempt.
+	Outputs []string `json:"outputs,omitempty"`
+	// Cache, if set, lets the task reuse the results of an identical task that succeeded.
+	Cache *CacheSpec `json:"cache,omitempty"`
+	// Exit classifies the exits of Cmd.
+	// If nil, the policy of the task's group is used.
+	Exit *ExitPolicy `json:"exit,omitempty"`
+	// Labels are used to select tasks and do not affect how they run.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Usage is the resources an attempt used.
+type Usage struct {
+	// UserCPU and SystemCPU are in seconds.
+	UserCPU    float64 `json:"usercpu"`
+	SystemCPU  float64 `json:"systemcpu"`
+	MaxRSS     int64   `json:"maxrss"`
+	ReadBytes  int64   `json:"readbytes"`
+	WriteBytes int64   `json:"writebytes"`
+	// Source tells how the usage was measured.
+	Source string `json:"source"`
+}
+
+// Attempt describes one run of a task.
+type Attempt struct {
+	Worker     string    `json:"worker"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Err        string    `json:"err,omitempty"`
+	Usage      Usage     `json:"usage"`
+	CachedFrom string    `json:"cachedfrom,omitempty"`
+	// Suspended is the number of seconds the attempt was suspended.
+	Suspended float64 `json:"suspended,omitempty"`
+	// ExitCode is the exit code of the command, or -1 if it did not exit.
+	// It is kept even if the exit counts as success.
+	ExitCode int `json:"exitcode"`
+	// Signal is the name of the signal that killed the command, if any.
+	Signal string `json:"signal,omitempty"`
+}
+
+// Task states.
+const (
+	Queued    = "queued"
+	Running   = "running"
+	Succeeded = "succeeded"
+	Failed    = "failed"
+	Killed    = "killed"
+)
+
+// TaskStatus is the state of a task.
+type TaskStatus struct {
+	// State is one of Queued, Running, Succeeded, Failed, and Killed.
+	State string `json:"state"`
+	// Summary describes the state for people.
+	Summary   string `json:"summary"`
+	Tries     int    `json:"tries"`
+	Err       string `json:"err,omitempty"`
+	Runner    string `json:"runner,omitempty"`
+	Suspended bool   `json:"suspended"`
+	Tmux      struct {
+		Session string `json:"session"`
+	} `json:"tmux"`
+	FailedOn   []string  `json:"failedon"`
+	Attempts   []Attempt `json:"attempts"`
+	CacheKey   string    `json:"cachekey,omitempty"`
+	CachedFrom string    `json:"cachedfrom,omitempty"`
+}
+
+// Finished reports whether the task will not run again
+// unless it is rerun or its tries are reset.
+func (s *TaskStatus) Finished() bool {
+	return s.State == Succeeded || s.State == Failed || s.State == Killed
+}
+
+// TaskInfo describes a task and its status.
+// Secrets in its environment are redacted.
+type TaskInfo struct {
+	Task
+	Status TaskStatus `json:"status"`
+}
+
+// Worker returns the worker running the task, or the one that ran its last attempt.
+func (t *TaskInfo) Worker() string {
+	if t.Status.Runner != "" || len(t.Status.Attempts) == 0 {
+		return t.Status.Runner
+	}
+	return t.Status.Attempts[len(t.Status.Attempts)-1].Worker
+}
+
+// TaskManifest is a task along with the settings of its group that it runs with.
+type TaskManifest struct {
+	TaskInfo
+	Group struct {
+		Env     []string `json:"env"`
+		Secrets []string `json:"secrets"`
+		Paused  Pause    `json:"paused"`
+	} `json:"group"`
+}
+
+// TaskPage is a page of a task listing.
+type TaskPage struct {
+	Tasks []TaskInfo `json:"tasks"`
+	// Next is the cursor of the next page, or empty if this is the last.
+	Next string `json:"next,omitempty"`
+}
+
+// ListTasks lists a page of the tasks of group.
+func (c *Client) ListTasks(ctx context.Context, group string, opts *ListOptions) (*TaskPage, error) {
+	var page TaskPage
+	if err := c.do(ctx, "GET", path("tasks", group)+opts.query(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// AllTasks lists every task of group, following the cursors of ListTasks.
+func (c *Client) AllTasks(ctx context.Context, group string, opts *ListOptions) ([]TaskInfo, error) {
+	o := listOptions(opts)
+	all := []TaskInfo{}
+	for {
+		page, err := c.ListTasks(ctx, group, &o)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Tasks...)
+		if page.Next == "" {
+			return all, nil
+		}
+		o.Cursor = page.Next
+	}
+}
+
+// AddedTasks tells which tasks were added.
+type AddedTasks struct {
+	Added []string `json:"added"`
+	// Exist are the tasks that were not added because the group has tasks of the same names.
+	Exist []string `json:"exist"`
+}
+
+type addTasksReq struct {
+	Tasks []Task `json:"tasks"`
+}
+
+// AddTasks adds tasks to group and queues them.
+// Tasks whose names are taken are not added, which is not an error.
+func (c *Client) AddTasks(ctx context.Context, group string, tasks []Task) (*AddedTasks, error) {
+	var added AddedTasks
+	if err := c.do(ctx, "POST", path("tasks", group), &addTasksReq{tasks}, &added); err != nil {
+		return nil, err
+	}
+	return &added, nil
+}
+
+// Task describes the named task of group.
+func (c *Client) Task(ctx context.Context, group, name string) (*TaskInfo, error) {
+	var t TaskInfo
+	if err := c.do(ctx, "GET", path("tasks", group, name), nil, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// TaskManifest returns the named task of group and the group settings it runs with.
+func (c *Client) TaskManifest(ctx context.Context, group, name string) (*TaskManifest, error) {
+	var m TaskManifest
+	if err := c.do(ctx, "GET", path("tasks", group, name, "manifest"), nil, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// RemoveTask kills and removes the named task of group.
+func (c *Client) RemoveTask(ctx context.Context, group, name string) error {
+	return c.do(ctx, "DELETE", path("tasks", group, name), nil, nil)
+}
+
+// ResetTaskTries sets the tries of the named task of group to 0,
+// so that a failed task is run again.
+func (c *Client) ResetTaskTries(ctx context.Context, group, name string) error {
+	req := struct {
+		Tries int `json:"tries"`
+	}{0}
+	return c.do(ctx, "PATCH", path("tasks", group, name), &req, nil)
+}
+
+// RerunTask queues a finished or killed task again.
+// Its tries are reset but its earlier attempts are kept.
+func (c *Client) RerunTask(ctx context.Context, group, name string) error {
+	return c.do(ctx, "POST", path("tasks", group, name, "rerun"), struct{}{}, nil)
+}
+
+// Clone holds the changes to make to a copy of a task.
+// Fields that are empty are copied from the task.
+type Clone struct {
+	// Name is the name of the copy, by default NAME-cloneN.
+	Name string   `json:"name,omitempty"`
+	Cmd  []string `json:"cmd,omitempty"`
+	// Env replaces the task's environment if it is not nil, even if empty.
+	Env []string `json:"env"`
+	WD  string   `json:"wd,omitempty"`
+}
+
+// CloneTask adds a copy of the named task of group and returns the name of the copy.
+func (c *Client) CloneTask(ctx context.Context, group, name string, clone *Clone) (string, error) {
+	if clone == nil {
+		clone = new(Clone)
+	}
+	var resp struct {
+		Name string `json:"name"`
+	}
+	err := c.do(ctx, "POST", path("tasks", group, name, "clone"), clone, &resp)
+	return resp.Name, err
+}
+
+// SuspendTask stops the running task with SIGSTOP.
+func (c *Client) SuspendTask(ctx context.Context, group, name string) error {
+	return c.do(ctx, "POST", path("tasks", group, name, "suspend"), struct{}{}, nil)
+}
+
+// ResumeTask continues a suspended task with SIGCONT.
+func (c *Client) ResumeTask(ctx context.Context, group, name string) error {
+	return c.do(ctx, "POST", path("tasks", group, name, "resume"), struct{}{}, nil)
+}
+
+// TaskOutput returns the output of the last attempt of the named task of group.
+// It is the contents of the task's tmux pane, so it may end with blank lines.
+func (c *Client) TaskOutput(ctx context.Context, group, name string) (string, error) {
+	return c.text(ctx, path("tasks", group, name, "out"))
+}
+
+// Session is a tmux session that a task runs in.
+type Session struct {
+	Session string `json:"session"`
+	// Alive reports whether the session still exists.
+	Alive bool `json:"alive"`
+}
+
+// TaskSession returns the tmux session of the last attempt of the named task of group.
+func (c *Client) TaskSession(ctx context.Context, group, name string) (*Session, error) {
+	var s Session
+	if err := c.do(ctx, "GET", path("tasks", group, name, "session"), nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Artifact is a file kept from an attempt of a task.
+type Artifact struct {
+	Attempt int `json:"attempt"`
+	// Path is relative to the task's working directory.
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// TaskArtifacts lists the artifacts of the named task of group.
+func (c *Client) TaskArtifacts(ctx context.Context, group, name string) ([]Artifact, error) {
+	var resp struct {
+		Artifacts []Artifact `json:"artifacts"`
+	}
+	if err := c.do(ctx, "GET", path("tasks", group, name, "artifacts"), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Artifacts, nil
+}
+
+// TaskArtifact returns the contents of an artifact of the named task of group.
+// The caller must close it.
+func (c *Client) TaskArtifact(ctx context.Context, group, name string, a Artifact) (io.ReadCloser, error) {
+	return c.stream(ctx, path("tasks", group, name, "artifacts", strconv.Itoa(a.Attempt))+"/"+escapeSlashed(a.Path))
+}
+
+// TaskArtifactsTar returns a tar of the artifacts of the named task of group,
+// holding ATTEMPT/PATH for each one. If attempt is positive,
+// only the artifacts of that attempt are included.
+// The caller must close it.
+func (c *Client) TaskArtifactsTar(ctx context.Context, group, name string, attempt int) (io.ReadCloser, error) {
+	rel := path("tasks", group, name, "artifacts.tar")
+	if attempt > 0 {
+		rel += "?attempt=" + strconv.Itoa(attempt)
+	}
+	return c.stream(ctx, rel)
+}
+
+// Selector picks the tasks or workers of a bulk operation.
+// Objects must match every field that is set.
+type Selector struct {
+	// Name is a glob matching names.
+	Name string `json:"name,omitempty"`
+	// State is a comma-separated list of states.
+	State string `json:"state,omitempty"`
+	// Label is KEY=VALUE, or KEY for any value. Only for tasks.
+	Label string `json:"label,omitempty"`
+	// Worker is the worker running a task, or that ran its last attempt. Only for tasks.
+	Worker string `json:"worker,omitempty"`
+}
+
+// Actions of bulk task operations.
+const (
+	ActionKill       = "kill"
+	ActionResetTries = "reset-tries"
+	ActionRerun      = "rerun"
+	ActionDelete     = "delete"
+)
+
+// BulkResult is the result of a bulk operation on one object.
+type BulkResult struct {
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type bulkReq struct {
+	Selector *Selector `json:"selector"`
+	Action   string    `json:"action"`
+}
+
+// BulkTasks applies action to every task of group that sel matches.
+// Failures on single tasks are reported in the results, not as errors.
+func (c *Client) BulkTasks(ctx context.Context, group string, sel *Selector, action string) ([]BulkResult, error) {
+	var resp struct {
+		Results []BulkResult `json:"results"`
+	}
+	if err := c.do(ctx, "POST", path("tasks", group, "bulk"), &bulkReq{sel, action}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
+
+// Wait picks the tasks to wait for.
+type Wait struct {
+	Names    []string  `json:"names,omitempty"`
+	Selector *Selector `json:"selector,omitempty"`
+	// Timeout is how many seconds the server waits before replying
+	// even though some tasks have not finished.
+	// The server uses its default if it is 0 and caps it at its maximum.
+	Timeout float64 `json:"timeout"`
+}
+
+// WaitedTask is the state of a task that was waited for.
+type WaitedTask struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Err      string `json:"err,omitempty"`
+	ExitCode int    `json:"exitcode"`
+}
+
+// Waited is the result of waiting for tasks.
+type Waited struct {
+	// Done reports whether every task has finished.
+	Done  bool         `json:"done"`
+	Tasks []WaitedTask `json:"tasks"`
+}
+
+// WaitTasks waits for tasks of group to finish or for the timeout to pass.
+func (c *Client) WaitTasks(ctx context.Context, group string, w *Wait) (*Waited, error) {
+	var resp Waited
+	if err := c.do(ctx, "POST", path("tasks", group, "wait"), w, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
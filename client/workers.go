@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"strings"
+)
+
+// Worker describes a worker.
+type Worker struct {
+	Name     string `json:"name"`
+	Manifest string `json:"manifest"`
+	// State is Created, Initializing, Ready, Busy, Draining, Drained, or Dead.
+	State       string `json:"state"`
+	FailedTasks int    `json:"failedtasks"`
+	Initialized bool   `json:"initialized"`
+	// InitVersion is the version of the group's init task the worker ran.
+	// It is 0 for shared workers.
+	InitVersion int    `json:"initversion,omitempty"`
+	RunningTask string `json:"runningtask,omitempty"`
+}
+
+// WorkerPage is a page of a worker listing.
+type WorkerPage struct {
+	Workers []Worker `json:"workers"`
+	// Next is the cursor of the next page, or empty if this is the last.
+	Next string `json:"next,omitempty"`
+}
+
+// ListWorkers lists a page of the workers of group.
+func (c *Client) ListWorkers(ctx context.Context, group string, opts *ListOptions) (*WorkerPage, error) {
+	var page WorkerPage
+	if err := c.do(ctx, "GET", workersPath(group)+opts.query(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// AllWorkers lists every worker of group, following the cursors of ListWorkers.
+func (c *Client) AllWorkers(ctx context.Context, group string, opts *ListOptions) ([]Worker, error) {
+	o := listOptions(opts)
+	all := []Worker{}
+	for {
+		page, err := c.ListWorkers(ctx, group, &o)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Workers...)
+		if page.Next == "" {
+			return all, nil
+		}
+		o.Cursor = page.Next
+	}
+}
+
+type newWorker struct {
+	Manifest string `json:"manifest"`
+}
+
+type addWorkersReq struct {
+	Workers []newWorker `json:"workers"`
+}
+
+// AddWorkers adds a worker to group for each manifest.
+func (c *Client) AddWorkers(ctx context.Context, group string, manifests []string) error {
+	req := addWorkersReq{Workers: make([]newWorker, len(manifests))}
+	for i, m := range manifests {
+		req.Workers[i].Manifest = m
+	}
+	return c.do(ctx, "POST", workersPath(group), &req, nil)
+}
+
+// Worker describes the named worker of group.
+func (c *Client) Worker(ctx context.Context, group, name string) (*Worker, error) {
+	var w Worker
+	if err := c.do(ctx, "GET", workersPath(group)+"/"+path(name), nil, &w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// RemoveWorker removes the named worker of group.
+func (c *Client) RemoveWorker(ctx context.Context, group, name string) error {
+	return c.do(ctx, "DELETE", workersPath(group)+"/"+path(name), nil, nil)
+}
+
+// ResetWorkerFailures sets the failure count of the named worker of group to 0,
+// so that a dead worker is used again.
+func (c *Client) ResetWorkerFailures(ctx context.Context, group, name string) error {
+	req := struct {
+		FailedTasks int `json:"failedtasks"`
+	}{0}
+	return c.do(ctx, "PATCH", workersPath(group)+"/"+path(name), &req, nil)
+}
+
+// WorkerManifest returns the manifest of the named worker of group.
+func (c *Client) WorkerManifest(ctx context.Context, group, name string) (string, error) {
+	m, err := c.text(ctx, workersPath(group)+"/"+path(name, "manifest"))
+	return strings.TrimSuffix(m, "\n"), err
+}
+
+// WorkerInitOutput returns the output of the init task of the named worker of group.
+func (c *Client) WorkerInitOutput(ctx context.Context, group, name string) (string, error) {
+	return c.text(ctx, workersPath(group)+"/"+path(name, "initout"))
+}
+
+// WorkerSession returns the tmux session of the init task of the named worker of group.
+func (c *Client) WorkerSession(ctx context.Context, group, name string) (*Session, error) {
+	var s Session
+	if err := c.do(ctx, "GET", workersPath(group)+"/"+path(name, "session"), nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Actions of bulk worker operations.
+const (
+	ActionResetFailures = "reset-failures"
+	// ActionDrain lets workers finish their running tasks but gives them no new ones.
+	ActionDrain  = "drain"
+	ActionRemove = "remove"
+)
+
+// BulkWorkers applies action to every worker of group that sel matches.
+// Failures on single workers are reported in the results, not as errors.
+func (c *Client) BulkWorkers(ctx context.Context, group string, sel *Selector, action string) ([]BulkResult, error) {
+	var resp struct {
+		Results []BulkResult `json:"results"`
+	}
+	if err := c.do(ctx, "POST", workersPath(group)+"/bulk", &bulkReq{sel, action}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
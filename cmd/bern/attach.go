@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/google/subcommands"
+	"github.com/uluyol/bernie/client"
+)
+
+type attachCmd struct {
+	init   bool
+	shared bool
+}
+
+func (c *attachCmd) Name() string     { return "attach" }
+func (c *attachCmd) Synopsis() string { return "attach to the tmux session of a task" }
+func (c *attachCmd) Usage() string {
+	return `bern attach [options] task
+bern attach -init [options] worker
+
+Attaches to the tmux session of the task's last attempt,
+or with -init, to that of the worker's init task.
+If the server is on another host, the command to run there is printed instead.
+
+`
+}
+
+func (c *attachCmd) SetFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.init, "init", false, "attach to the init task of a worker")
+	fs.BoolVar(&c.shared, "shared", false, "with -init, use a shared worker instead of the group's")
+}
+
+func (c *attachCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() != 1 {
+		log.Print("need exactly one task or worker")
+		return subcommands.ExitUsageError
+	}
+	name := fs.Arg(0)
+	what := "task " + name
+	var info *client.Session
+	var err error
+	if c.init {
+		what = "init task of worker " + name
+		info, err = cl.WorkerSession(ctx, pool(c.shared), name)
+	} else {
+		info, err = cl.TaskSession(ctx, group, name)
+	}
+	if err != nil {
+		log.Print(err)
+		return subcommands.ExitFailure
+	}
+	if !info.Alive {
+		log.Printf("tmux session %s of the %s is gone; it is killed when the task is retried, rerun, or removed", info.Session, what)
+		return subcommands.ExitFailure
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		log.Printf("invalid addr: %v", err)
+		return subcommands.ExitUsageError
+	}
+	host := u.Hostname()
+	if !isLocalHost(host) {
+		fmt.Printf("ssh -t %s tmux attach -t '=%s'\n", host, info.Session)
+		return subcommands.ExitSuccess
+	}
+
+	tmux, err := exec.LookPath("tmux")
+	if err != nil {
+		log.Print(err)
+		return subcommands.ExitFailure
+	}
+	argv := []string{"tmux", "attach", "-t", "=" + info.Session}
+	if os.Getenv("TMUX") != "" {
+		// Attaching from inside tmux would nest sessions.
+		argv = []string{"tmux", "switch-client", "-t", "=" + info.Session}
+	}
+	err = syscall.Exec(tmux, argv, os.Environ())
+	log.Printf("unable to run tmux: %v", err)
+	return subcommands.ExitFailure
+}
+
+// isLocalHost reports whether host names this machine.
+func isLocalHost(host string) bool {
+	if host == "" || host == "localhost" {
+		return true
+	}
+	if hostname, err := os.Hostname(); err == nil && host == hostname {
+		return true
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = net.LookupIP(host); err != nil {
+			return false
+		}
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, ip := range ips {
+		if ip.IsLoopback() || ip.IsUnspecified() {
+			return true
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
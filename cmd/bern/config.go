@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Profile holds the settings used to talk to one bernie server.
+type Profile struct {
+	Addr  string `json:"addr"`
+	Group string `json:"group"`
+	// Token is sent as a bearer token, for proxies in front of the server.
+	Token string `json:"token"`
+	// Env is the default env policy of commands that send an environment.
+	Env string `json:"env"`
+}
+
+// Config is the contents of the config file.
+type Config struct {
+	// Default names the profile used when none is chosen.
+	Default  string             `json:"default"`
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// configPath returns the path of the config file.
+func configPath() (string, error) {
+	if p := os.Getenv("BERN_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bern", "config.json"), nil
+}
+
+// readConfig reads the config file.
+// A missing file is treated as an empty config.
+func readConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return new(Config), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(Config)
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// profile returns the named profile, or the default one if name is empty.
+// It returns the zero Profile if no name is given and there is no default.
+func (c *Config) profile(name string) (Profile, error) {
+	if name == "" {
+		name = c.Default
+		if name == "" {
+			return Profile{}, nil
+		}
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		var names []string
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Profile{}, fmt.Errorf("unknown profile %q, have: %s", name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// applyProfile sets the global flags that were not given explicitly
+// from the profile chosen by -profile, BERN_PROFILE, or the config's default.
+func applyProfile(name string) error {
+	if name == "" {
+		name = os.Getenv("BERN_PROFILE")
+	}
+	path, err := configPath()
+	if err != nil {
+		if name == "" {
+			return nil
+		}
+		return fmt.Errorf("unable to find config file: %v", err)
+	}
+	cfg, err := readConfig(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config: %v", err)
+	}
+	p, err := cfg.profile(name)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if p.Addr != "" && !set["addr"] {
+		addr = p.Addr
+	}
+	if p.Group != "" && !set["group"] {
+		group = p.Group
+	}
+	if p.Token != "" && !set["token"] {
+		token = p.Token
+	}
+	if p.Env != "" {
+		if _, err := selectEnv(p.Env); err != nil {
+			return fmt.Errorf("%s: profile env: %v", path, err)
+		}
+		defaultEnvPolicy = p.Env
+	}
+	return nil
+}
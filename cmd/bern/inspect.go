@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/uluyol/bernie/client"
+)
+
+type formatFlags struct {
+	format string
+}
+
+func (f *formatFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.format, "format", "table", "output format: table or json")
+}
+
+func (f *formatFlags) json() (bool, error) {
+	switch f.format {
+	case "table":
+		return false, nil
+	case "json":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown format %q", f.format)
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(b, '\n'))
+	return err
+}
+
+// run runs fn and turns its error into an exit status.
+func run(fn func() error) subcommands.ExitStatus {
+	if err := fn(); err != nil {
+		log.Print(err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+}
+
+// pool returns the group to pass to worker methods of the client:
+// the group, or the shared pool if shared is set.
+func pool(shared bool) string {
+	if shared {
+		return client.Shared
+	}
+	return group
+}
+
+// states splits a comma-separated list of states.
+func states(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// dash returns s, or "-" if it is empty, for table cells.
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+type groupListCmd struct {
+	formatFlags
+	prefix string
+	state  string
+}
+
+func (c *groupListCmd) Name() string     { return "group-list" }
+func (c *groupListCmd) Synopsis() string { return "list groups" }
+func (c *groupListCmd) Usage() string    { return "bern group-list [options]\n" }
+
+func (c *groupListCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+	fs.StringVar(&c.prefix, "prefix", "", "only list groups whose names start with this")
+	fs.StringVar(&c.state, "state", "", "comma-separated states to list: active, paused")
+}
+
+func (c *groupListCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	return run(func() error {
+		asJSON, err := c.json()
+		if err != nil {
+			return err
+		}
+		groups, err := cl.AllGroups(ctx, &client.ListOptions{Prefix: c.prefix, States: states(c.state)})
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			return printJSON(groups)
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "NAME\tPAUSED\tQUEUED\tRUNNING\tSUCCEEDED\tFAILED\tKILLED\tWORKERS")
+		for _, g := range groups {
+			fmt.Fprintf(tw, "%s\t%t\t%d\t%d\t%d\t%d\t%d\t%d\n", g.Name, g.Paused.Paused,
+				g.Tasks[client.Queued], g.Tasks[client.Running], g.Tasks[client.Succeeded], g.Tasks[client.Failed],
+				g.Tasks[client.Killed], g.Workers)
+		}
+		return tw.Flush()
+	})
+}
+
+type taskListCmd struct {
+	formatFlags
+	prefix string
+	state  string
+}
+
+func (c *taskListCmd) Name() string     { return "task-list" }
+func (c *taskListCmd) Synopsis() string { return "list the tasks of a group" }
+func (c *taskListCmd) Usage() string    { return "bern task-list [options]\n" }
+
+func (c *taskListCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+	fs.StringVar(&c.prefix, "prefix", "", "only list tasks whose names start with this")
+	fs.StringVar(&c.state, "state", "", "comma-separated states to list: queued, running, succeeded, failed, killed")
+}
+
+func (c *taskListCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	return run(func() error {
+		asJSON, err := c.json()
+		if err != nil {
+			return err
+		}
+		tasks, err := cl.AllTasks(ctx, group, &client.ListOptions{Prefix: c.prefix, States: states(c.state)})
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			return printJSON(tasks)
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "NAME\tSTATE\tTRIES\tWORKER\tERR")
+		for _, t := range tasks {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", t.Name, t.Status.State, t.Status.Tries, dash(t.Worker()), dash(t.Status.Err))
+		}
+		return tw.Flush()
+	})
+}
+
+type taskStatusCmd struct {
+	formatFlags
+}
+
+func (c *taskStatusCmd) Name() string     { return "task-status" }
+func (c *taskStatusCmd) Synopsis() string { return "show the status and attempts of a task" }
+func (c *taskStatusCmd) Usage() string    { return "bern task-status [options] task\n" }
+
+func (c *taskStatusCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+}
+
+func (c *taskStatusCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() != 1 {
+		log.Print("need exactly one task")
+		return subcommands.ExitUsageError
+	}
+	return run(func() error {
+		asJSON, err := c.json()
+		if err != nil {
+			return err
+		}
+		t, err := cl.Task(ctx, group, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			return printJSON(t)
+		}
+		tw := newTable()
+		fmt.Fprintf(tw, "Name:\t%s\n", t.Name)
+		fmt.Fprintf(tw, "Cmd:\t%s\n", strings.Join(t.Cmd, " "))
+		fmt.Fprintf(tw, "WD:\t%s\n", t.WD)
+		fmt.Fprintf(tw, "State:\t%s (%s)\n", t.Status.State, t.Status.Summary)
+		fmt.Fprintf(tw, "Tries:\t%d\n", t.Status.Tries)
+		if t.Status.Runner != "" {
+			fmt.Fprintf(tw, "Runner:\t%s\n", t.Status.Runner)
+		}
+		if t.Status.Err != "" {
+			fmt.Fprintf(tw, "Err:\t%s\n", t.Status.Err)
+		}
+		if len(t.Status.FailedOn) > 0 {
+			fmt.Fprintf(tw, "Failed on:\t%s\n", strings.Join(t.Status.FailedOn, " "))
+		}
+		if len(t.Labels) > 0 {
+			var labels []string
+			for k, v := range t.Labels {
+				labels = append(labels, k+"="+v)
+			}
+			sort.Strings(labels)
+			fmt.Fprintf(tw, "Labels:\t%s\n", strings.Join(labels, " "))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if len(t.Status.Attempts) == 0 {
+			return nil
+		}
+		fmt.Println()
+		tw = newTable()
+		fmt.Fprintln(tw, "ATTEMPT\tWORKER\tSTART\tDURATION\tERR")
+		for i, a := range t.Status.Attempts {
+			errMsg := a.Err
+			if a.CachedFrom != "" {
+				errMsg = "cached from " + a.CachedFrom
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", i+1, dash(a.Worker), a.Start.Local().Format("2006-01-02 15:04:05"),
+				a.End.Sub(a.Start).Round(time.Millisecond), dash(errMsg))
+		}
+		return tw.Flush()
+	})
+}
+
+type taskOutCmd struct {
+	formatFlags
+}
+
+func (c *taskOutCmd) Name() string     { return "task-out" }
+func (c *taskOutCmd) Synopsis() string { return "print the output of a task" }
+func (c *taskOutCmd) Usage() string    { return "bern task-out [options] task\n" }
+
+func (c *taskOutCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+}
+
+func (c *taskOutCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() != 1 {
+		log.Print("need exactly one task")
+		return subcommands.ExitUsageError
+	}
+	return run(func() error {
+		return printText(&c.formatFlags, fs.Arg(0), func() (string, error) {
+			return cl.TaskOutput(ctx, group, fs.Arg(0))
+		})
+	})
+}
+
+// printText prints the plain text that get returns,
+// or a JSON object holding it and name with -format json.
+func printText(f *formatFlags, name string, get func() (string, error)) error {
+	asJSON, err := f.json()
+	if err != nil {
+		return err
+	}
+	out, err := get()
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(struct {
+			Name   string `json:"name"`
+			Output string `json:"output"`
+		}{name, out})
+	}
+	_, err = os.Stdout.WriteString(out)
+	return err
+}
+
+type taskRmCmd struct {
+	formatFlags
+}
+
+func (c *taskRmCmd) Name() string     { return "task-rm" }
+func (c *taskRmCmd) Synopsis() string { return "kill and remove tasks" }
+func (c *taskRmCmd) Usage() string    { return "bern task-rm [options] task...\n" }
+
+func (c *taskRmCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+}
+
+func (c *taskRmCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	return each(&c.formatFlags, fs, func(name string) error {
+		return cl.RemoveTask(ctx, group, name)
+	}, "removed")
+}
+
+type taskResetCmd struct {
+	formatFlags
+}
+
+func (c *taskResetCmd) Name() string     { return "task-reset" }
+func (c *taskResetCmd) Synopsis() string { return "reset the tries of tasks" }
+func (c *taskResetCmd) Usage() string    { return "bern task-reset [options] task...\n" }
+
+func (c *taskResetCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+}
+
+func (c *taskResetCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	return each(&c.formatFlags, fs, func(name string) error {
+		return cl.ResetTaskTries(ctx, group, name)
+	}, "reset")
+}
+
+// each calls fn with each named argument.
+// It prints one line per name, or a JSON list of results with -format json.
+func each(f *formatFlags, fs *flag.FlagSet, fn func(name string) error, done string) subcommands.ExitStatus {
+	if fs.NArg() == 0 {
+		log.Print("no names given")
+		return subcommands.ExitUsageError
+	}
+	asJSON, err := f.json()
+	if err != nil {
+		log.Print(err)
+		return subcommands.ExitUsageError
+	}
+	var results []client.BulkResult
+	status := subcommands.ExitSuccess
+	for _, name := range fs.Args() {
+		r := client.BulkResult{Name: name, Success: true}
+		if err := fn(name); err != nil {
+			r.Success = false
+			r.Reason = err.Error()
+			status = subcommands.ExitFailure
+		}
+		results = append(results, r)
+		if asJSON {
+			continue
+		}
+		if r.Success {
+			fmt.Println(done, name)
+		} else {
+			log.Printf("%s: %s", name, r.Reason)
+		}
+	}
+	if asJSON {
+		if err := printJSON(results); err != nil {
+			log.Print(err)
+			return subcommands.ExitFailure
+		}
+	}
+	return status
+}
+
+type workerListCmd struct {
+	formatFlags
+	prefix string
+	state  string
+	shared bool
+}
+
+func (c *workerListCmd) Name() string     { return "worker-list" }
+func (c *workerListCmd) Synopsis() string { return "list the workers of a group" }
+func (c *workerListCmd) Usage() string    { return "bern worker-list [options]\n" }
+
+func (c *workerListCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+	fs.StringVar(&c.prefix, "prefix", "", "only list workers whose names start with this")
+	fs.StringVar(&c.state, "state", "", "comma-separated states to list, e.g. Ready,Dead")
+	fs.BoolVar(&c.shared, "shared", false, "list the shared workers instead of the group's")
+}
+
+func (c *workerListCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	return run(func() error {
+		asJSON, err := c.json()
+		if err != nil {
+			return err
+		}
+		workers, err := cl.AllWorkers(ctx, pool(c.shared), &client.ListOptions{Prefix: c.prefix, States: states(c.state)})
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			return printJSON(workers)
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "NAME\tSTATE\tFAILED\tINIT\tTASK")
+		for _, w := range workers {
+			init := "-"
+			if w.InitVersion > 0 {
+				init = fmt.Sprintf("v%d", w.InitVersion)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", w.Name, w.State, w.FailedTasks, init, dash(w.RunningTask))
+		}
+		return tw.Flush()
+	})
+}
+
+type workerRmCmd struct {
+	formatFlags
+	shared bool
+}
+
+func (c *workerRmCmd) Name() string     { return "worker-rm" }
+func (c *workerRmCmd) Synopsis() string { return "remove workers" }
+func (c *workerRmCmd) Usage() string    { return "bern worker-rm [options] worker...\n" }
+
+func (c *workerRmCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+	fs.BoolVar(&c.shared, "shared", false, "remove shared workers instead of the group's")
+}
+
+func (c *workerRmCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	return each(&c.formatFlags, fs, func(name string) error {
+		return cl.RemoveWorker(ctx, pool(c.shared), name)
+	}, "removed")
+}
+
+type workerResetCmd struct {
+	formatFlags
+	shared bool
+}
+
+func (c *workerResetCmd) Name() string     { return "worker-reset" }
+func (c *workerResetCmd) Synopsis() string { return "reset the failure counts of workers" }
+func (c *workerResetCmd) Usage() string    { return "bern worker-reset [options] worker...\n" }
+
+func (c *workerResetCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+	fs.BoolVar(&c.shared, "shared", false, "reset shared workers instead of the group's")
+}
+
+func (c *workerResetCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	return each(&c.formatFlags, fs, func(name string) error {
+		return cl.ResetWorkerFailures(ctx, pool(c.shared), name)
+	}, "reset")
+}
+
+type workerInitOutCmd struct {
+	formatFlags
+	shared bool
+}
+
+func (c *workerInitOutCmd) Name() string     { return "worker-initout" }
+func (c *workerInitOutCmd) Synopsis() string { return "print the output of a worker's init task" }
+func (c *workerInitOutCmd) Usage() string    { return "bern worker-initout [options] worker\n" }
+
+func (c *workerInitOutCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+	fs.BoolVar(&c.shared, "shared", false, "use a shared worker instead of the group's")
+}
+
+func (c *workerInitOutCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() != 1 {
+		log.Print("need exactly one worker")
+		return subcommands.ExitUsageError
+	}
+	return run(func() error {
+		return printText(&c.formatFlags, fs.Arg(0), func() (string, error) {
+			return cl.WorkerInitOutput(ctx, pool(c.shared), fs.Arg(0))
+		})
+	})
+}
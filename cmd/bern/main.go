@@ -2,54 +2,82 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
-	"net/http"
-	"net/url"
 	"os"
+	"os/user"
+	"strings"
 	"time"
 
 	"github.com/google/subcommands"
+	"github.com/uluyol/bernie/client"
 	"github.com/uluyol/bernie/internal"
 )
 
 var (
 	group string
 	addr  string
+	token string
+
+	// defaultEnvPolicy is the default of -env, which the profile may change.
+	defaultEnvPolicy = "all"
+
+	// cl talks to the server at addr.
+	cl *client.Client
 )
 
-type Task struct {
-	Name string   `json:"name"`
-	Cmd  []string `json:"cmd"`
-	Env  []string `json:"env"`
-	WD   string   `json:"wd"`
+// exitFlags are the flags that build an ExitPolicy.
+type exitFlags struct {
+	success, retryable, permanent string
 }
 
-type GroupAddReq struct {
-	Name string `json:"name'`
-	Init Task   `json:"init"`
+func (f *exitFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.success, "success", "", "comma-separated exit codes or signals that count as success (default 0)")
+	fs.StringVar(&f.retryable, "retryable", "", "comma-separated exit codes or signals to retry, others fail permanently")
+	fs.StringVar(&f.permanent, "permanent", "", "comma-separated exit codes or signals that fail without retrying")
 }
 
-type TasksAddReq struct {
-	Tasks []Task `json:"tasks"`
+// policy returns the ExitPolicy given by the flags, or nil if none were set.
+func (f *exitFlags) policy() *client.ExitPolicy {
+	if f.success == "" && f.retryable == "" && f.permanent == "" {
+		return nil
+	}
+	split := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		return strings.Split(s, ",")
+	}
+	return &client.ExitPolicy{
+		Success:   split(f.success),
+		Retryable: split(f.retryable),
+		Permanent: split(f.permanent),
+	}
 }
 
-type Worker struct {
-	Manifest string `json:"manifest"`
-}
+// stringsFlag is a flag that may be repeated to build a list.
+type stringsFlag []string
 
-type WorkersAddReq struct {
-	Workers []Worker `json:"workers"`
-}
+func (f *stringsFlag) String() string     { return strings.Join(*f, ",") }
+func (f *stringsFlag) Set(v string) error { *f = append(*f, v); return nil }
 
 type groupAddCmd struct {
-	wd string
+	wd            string
+	share         float64
+	strictRetries bool
+	exit          exitFlags
+	envPolicy     string
+	setEnv        stringsFlag
+	secretsFile   string
+	schema        string
+	maxTries      int
+	maxFails      int
 }
 
 func (c *groupAddCmd) Name() string     { return "group-add" }
@@ -58,6 +86,15 @@ func (c *groupAddCmd) Usage() string    { return "bern group-add [options] initc
 
 func (c *groupAddCmd) SetFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.wd, "wd", "", "working directory for init task, empty for current dir")
+	fs.Float64Var(&c.share, "share", 0, "weight when using shared workers, 0 to not use them")
+	fs.BoolVar(&c.strictRetries, "strictretries", false, "never retry a task on a worker it failed on")
+	c.exit.register(fs)
+	fs.StringVar(&c.envPolicy, "env", defaultEnvPolicy, envPolicyUsage+" for the init task")
+	fs.Var(&c.setEnv, "setenv", "KEY=VALUE to give every task in the group (may be repeated)")
+	fs.StringVar(&c.secretsFile, "secretsfile", "", "file of KEY=VALUE lines to keep as group secrets")
+	fs.StringVar(&c.schema, "manifestschema", "", "comma-separated key:type pairs that worker manifests, as JSON objects, must have")
+	fs.IntVar(&c.maxTries, "maxtries", 0, "max allowable tries for a task, 0 for the server's default")
+	fs.IntVar(&c.maxFails, "maxfails", 0, "max allowed failures on a worker, 0 for the server's default")
 }
 
 func (c *groupAddCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
@@ -66,72 +103,573 @@ func (c *groupAddCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...int
 		return subcommands.ExitUsageError
 	}
 
-	refURL, err := url.Parse(addr)
+	init, err := initTask(fs.Args(), c.wd, c.envPolicy)
 	if err != nil {
-		log.Printf("invalid addr: %v", err)
+		log.Print(err)
 		return subcommands.ExitUsageError
 	}
+	var secrets map[string]string
+	if c.secretsFile != "" {
+		secrets, err = readSecrets(c.secretsFile)
+		if err != nil {
+			log.Printf("unable to read secrets: %v", err)
+			return subcommands.ExitFailure
+		}
+	}
 
-	wd := c.wd
+	var schema map[string]string
+	if c.schema != "" {
+		schema = make(map[string]string)
+		for _, kt := range strings.Split(c.schema, ",") {
+			i := strings.LastIndex(kt, ":")
+			if i < 0 {
+				log.Printf("manifest schema entry %q is not key:type", kt)
+				return subcommands.ExitUsageError
+			}
+			schema[kt[:i]] = kt[i+1:]
+		}
+	}
+
+	return run(func() error {
+		return cl.AddGroup(ctx, &client.NewGroup{
+			Name:           group,
+			Init:           init,
+			Env:            c.setEnv,
+			Secrets:        secrets,
+			ManifestSchema: schema,
+			Share:          c.share,
+			StrictRetries:  c.strictRetries,
+			Exit:           c.exit.policy(),
+			MaxTries:       c.maxTries,
+			MaxFails:       c.maxFails,
+		})
+	})
+}
+
+// initTask returns the init task that runs cmd in wd, or the current dir if wd is empty,
+// with the environment chosen by envPolicy.
+func initTask(cmd []string, wd, envPolicy string) (client.Task, error) {
 	if wd == "" {
 		t, err := os.Getwd()
 		if err != nil {
-			log.Printf("unable to get working dir: %v", err)
-			return subcommands.ExitFailure
+			return client.Task{}, fmt.Errorf("unable to get working dir: %v", err)
 		}
 		wd = t
 	}
+	env, err := selectEnv(envPolicy)
+	if err != nil {
+		return client.Task{}, err
+	}
+	return client.Task{
+		Name: "init",
+		Cmd:  cmd,
+		Env:  env,
+		WD:   wd,
+	}, nil
+}
 
-	req := GroupAddReq{
-		Name: group,
-		Init: Task{
-			Name: "init",
-			Cmd:  fs.Args(),
-			Env:  os.Environ(),
-			WD:   wd,
-		},
+const envPolicyUsage = "environment to send: all, none, or comma-separated variable names"
+
+// selectEnv returns the variables of this process's environment chosen by policy.
+func selectEnv(policy string) ([]string, error) {
+	switch policy {
+	case "all":
+		return os.Environ(), nil
+	case "none":
+		return nil, nil
+	case "":
+		return nil, errors.New("empty env policy")
+	}
+	var env []string
+	for _, k := range strings.Split(policy, ",") {
+		if v, ok := os.LookupEnv(k); ok {
+			env = append(env, k+"="+v)
+		}
 	}
+	return env, nil
+}
 
-	b, err := json.Marshal(&req)
+// readSecrets reads KEY=VALUE lines from path.
+// Blank lines and lines starting with # are ignored.
+func readSecrets(path string) (map[string]string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		log.Printf("unable to encode request: %v", err)
-		return subcommands.ExitFailure
+		return nil, err
+	}
+	defer f.Close()
+	secrets := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %q is not KEY=VALUE", line)
+		}
+		secrets[kv[0]] = kv[1]
 	}
+	return secrets, s.Err()
+}
+
+type groupRmCmd struct{}
+
+func (c *groupRmCmd) Name() string     { return "group-rm" }
+func (c *groupRmCmd) Synopsis() string { return "remove a group" }
+func (c *groupRmCmd) Usage() string {
+	return `bern group-rm
 
-	u, err := refURL.Parse("groups/add")
+The group's tasks are killed and its workers are removed.
+
+`
+}
+func (c *groupRmCmd) SetFlags(fs *flag.FlagSet) {}
+
+func (c *groupRmCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	return run(func() error { return cl.RemoveGroup(ctx, group) })
+}
+
+type groupSetCmd struct {
+	limits client.GroupLimits
+}
+
+func (c *groupSetCmd) Name() string     { return "group-set" }
+func (c *groupSetCmd) Synopsis() string { return "change the limits of a group" }
+func (c *groupSetCmd) Usage() string    { return "bern group-set [options]\n" }
+
+func (c *groupSetCmd) SetFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.limits.MaxTries, "maxtries", 0, "max allowable tries for a task, 0 to leave unchanged")
+	fs.IntVar(&c.limits.MaxFails, "maxfails", 0, "max allowed failures on a worker, 0 to leave unchanged")
+}
+
+func (c *groupSetCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if c.limits.MaxTries <= 0 && c.limits.MaxFails <= 0 {
+		log.Print("nothing to change")
+		return subcommands.ExitUsageError
+	}
+	return run(func() error { return cl.SetGroupLimits(ctx, group, c.limits) })
+}
+
+type groupInitCmd struct {
+	wd        string
+	envPolicy string
+	maxReinit int
+}
+
+func (c *groupInitCmd) Name() string     { return "group-init" }
+func (c *groupInitCmd) Synopsis() string { return "replace the init task of a group" }
+func (c *groupInitCmd) Usage() string {
+	return `bern group-init [options] initcmd args...
+
+Workers are re-initialized with the new init task as they become idle.
+Prints the version of the new init task.
+
+`
+}
+
+func (c *groupInitCmd) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.wd, "wd", "", "working directory for init task, empty for current dir")
+	fs.StringVar(&c.envPolicy, "env", defaultEnvPolicy, envPolicyUsage+" for the init task")
+	fs.IntVar(&c.maxReinit, "max", 1, "maximum number of workers to re-initialize at a time")
+}
+
+func (c *groupInitCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() == 0 {
+		log.Print("no init command specified")
+		return subcommands.ExitUsageError
+	}
+	init, err := initTask(fs.Args(), c.wd, c.envPolicy)
 	if err != nil {
-		log.Printf("unable to construct request url: %v", err)
-		return subcommands.ExitFailure
+		log.Print(err)
+		return subcommands.ExitUsageError
+	}
+	return run(func() error {
+		v, err := cl.SetInitTask(ctx, group, &init, c.maxReinit)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("init task version %d\n", v)
+		return nil
+	})
+}
+
+type groupPauseCmd struct {
+	reason string
+}
+
+func (c *groupPauseCmd) Name() string     { return "group-pause" }
+func (c *groupPauseCmd) Synopsis() string { return "stop a group from starting queued tasks" }
+func (c *groupPauseCmd) Usage() string {
+	return `bern group-pause [options]
+
+Running tasks are left alone.
+
+`
+}
+
+func (c *groupPauseCmd) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.reason, "reason", "", "why the group is paused")
+}
+
+func (c *groupPauseCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	by := "unknown"
+	if u, err := user.Current(); err == nil {
+		by = u.Username
+	}
+	if h, err := os.Hostname(); err == nil {
+		by += "@" + h
+	}
+	return run(func() error { return cl.PauseGroup(ctx, group, by, c.reason) })
+}
+
+type groupResumeCmd struct{}
+
+func (c *groupResumeCmd) Name() string              { return "group-resume" }
+func (c *groupResumeCmd) Synopsis() string          { return "let a paused group start queued tasks" }
+func (c *groupResumeCmd) Usage() string             { return "bern group-resume\n" }
+func (c *groupResumeCmd) SetFlags(fs *flag.FlagSet) {}
+
+func (c *groupResumeCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	return run(func() error { return cl.ResumeGroup(ctx, group) })
+}
+
+type webhookAddCmd struct {
+	events stringsFlag
+}
+
+func (c *webhookAddCmd) Name() string     { return "webhook-add" }
+func (c *webhookAddCmd) Synopsis() string { return "send the events of a group to a url" }
+func (c *webhookAddCmd) Usage() string {
+	return `bern webhook-add [options] url
+
+The server POSTs a JSON payload to url for each event of the group.
+Events are task-succeeded, task-retrying, task-failed, and queue-empty.
+Failed deliveries are retried with backoff.
+Prints the ID of the webhook.
+
+`
+}
+
+func (c *webhookAddCmd) SetFlags(fs *flag.FlagSet) {
+	fs.Var(&c.events, "event", "event to send (may be repeated, all if not given)")
+}
+
+func (c *webhookAddCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() != 1 {
+		log.Print("need exactly one url")
+		return subcommands.ExitUsageError
+	}
+	return run(func() error {
+		id, err := cl.AddWebhook(ctx, group, fs.Arg(0), c.events...)
+		if err != nil {
+			return err
+		}
+		fmt.Println(id)
+		return nil
+	})
+}
+
+type webhookRmCmd struct{}
+
+func (c *webhookRmCmd) Name() string              { return "webhook-rm" }
+func (c *webhookRmCmd) Synopsis() string          { return "remove a webhook from a group" }
+func (c *webhookRmCmd) Usage() string             { return "bern webhook-rm id\n" }
+func (c *webhookRmCmd) SetFlags(fs *flag.FlagSet) {}
+
+func (c *webhookRmCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() != 1 {
+		log.Print("need exactly one webhook id")
+		return subcommands.ExitUsageError
+	}
+	var id int
+	if _, err := fmt.Sscan(fs.Arg(0), &id); err != nil {
+		log.Printf("bad webhook id %q", fs.Arg(0))
+		return subcommands.ExitUsageError
+	}
+	return run(func() error { return cl.RemoveWebhook(ctx, group, id) })
+}
+
+type webhookListCmd struct {
+	formatFlags
+}
+
+func (c *webhookListCmd) Name() string { return "webhook-list" }
+func (c *webhookListCmd) Synopsis() string {
+	return "show the webhooks of a group and their deliveries"
+}
+func (c *webhookListCmd) Usage() string { return "bern webhook-list [options]\n" }
+
+func (c *webhookListCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+}
+
+func (c *webhookListCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	return run(func() error {
+		asJSON, err := c.json()
+		if err != nil {
+			return err
+		}
+		hooks, err := cl.Webhooks(ctx, group)
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			return printJSON(hooks)
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "ID\tURL\tEVENTS\tLAST DELIVERY")
+		for _, h := range hooks {
+			events := "all"
+			if len(h.Events) > 0 {
+				events = strings.Join(h.Events, ",")
+			}
+			last := "-"
+			if n := len(h.Deliveries); n > 0 {
+				d := h.Deliveries[n-1]
+				switch {
+				case d.Delivered:
+					last = "delivered"
+				case d.Err != "":
+					last = "failed: " + d.Err
+				default:
+					last = "pending"
+				}
+				last = fmt.Sprintf("%s %s (%s)", d.Event, d.Time.Local().Format("2006-01-02 15:04:05"), last)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", h.ID, h.URL, events, last)
+		}
+		return tw.Flush()
+	})
+}
+
+type taskSuspendCmd struct {
+	resume bool
+}
+
+func (c *taskSuspendCmd) Name() string {
+	if c.resume {
+		return "task-resume"
+	}
+	return "task-suspend"
+}
+
+func (c *taskSuspendCmd) Synopsis() string {
+	if c.resume {
+		return "continue a suspended task"
+	}
+	return "stop a running task with SIGSTOP"
+}
+
+func (c *taskSuspendCmd) Usage() string             { return "bern " + c.Name() + " task\n" }
+func (c *taskSuspendCmd) SetFlags(fs *flag.FlagSet) {}
+
+func (c *taskSuspendCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() != 1 {
+		log.Print("need exactly one task")
+		return subcommands.ExitUsageError
+	}
+	return run(func() error {
+		if c.resume {
+			return cl.ResumeTask(ctx, group, fs.Arg(0))
+		}
+		return cl.SuspendTask(ctx, group, fs.Arg(0))
+	})
+}
+
+type taskRerunCmd struct{}
+
+func (c *taskRerunCmd) Name() string     { return "task-rerun" }
+func (c *taskRerunCmd) Synopsis() string { return "queue a finished or killed task again" }
+func (c *taskRerunCmd) Usage() string {
+	return `bern task-rerun task
+
+The task's tries are reset but its earlier attempts are kept.
+
+`
+}
+func (c *taskRerunCmd) SetFlags(fs *flag.FlagSet) {}
+
+func (c *taskRerunCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() != 1 {
+		log.Print("need exactly one task")
+		return subcommands.ExitUsageError
 	}
+	return run(func() error { return cl.RerunTask(ctx, group, fs.Arg(0)) })
+}
+
+type taskCloneCmd struct {
+	name      string
+	wd        string
+	envPolicy string
+}
+
+func (c *taskCloneCmd) Name() string     { return "task-clone" }
+func (c *taskCloneCmd) Synopsis() string { return "add a copy of a task" }
+func (c *taskCloneCmd) Usage() string {
+	return `bern task-clone [options] task [cmd args...]
+
+If cmd is given, it replaces the command of the copy.
+Prints the name of the copy.
+
+`
+}
+
+func (c *taskCloneCmd) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.name, "name", "", "name of the copy, empty to derive one")
+	fs.StringVar(&c.wd, "wd", "", "working directory of the copy, empty to keep the task's")
+	fs.StringVar(&c.envPolicy, "env", "", envPolicyUsage+", empty to keep the task's")
+}
+
+func (c *taskCloneCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() == 0 {
+		log.Print("no task specified")
+		return subcommands.ExitUsageError
+	}
+	clone := client.Clone{
+		Name: c.name,
+		Cmd:  fs.Args()[1:],
+		WD:   c.wd,
+	}
+	if c.envPolicy != "" {
+		env, err := selectEnv(c.envPolicy)
+		if err != nil {
+			log.Print(err)
+			return subcommands.ExitUsageError
+		}
+		if env == nil {
+			env = []string{}
+		}
+		clone.Env = env
+	}
+	return run(func() error {
+		name, err := cl.CloneTask(ctx, group, fs.Arg(0), &clone)
+		if err != nil {
+			return err
+		}
+		fmt.Println(name)
+		return nil
+	})
+}
+
+type tasksBulkCmd struct {
+	formatFlags
+	sel client.Selector
+}
+
+func (c *tasksBulkCmd) Name() string     { return "tasks-bulk" }
+func (c *tasksBulkCmd) Synopsis() string { return "apply an action to every matching task" }
+func (c *tasksBulkCmd) Usage() string {
+	return `bern tasks-bulk [options] action
+
+Applies action to every task in the group that matches all given options.
+At least one option must be given.
+Actions are kill, reset-tries, rerun and delete.
+
+`
+}
+
+func (c *tasksBulkCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+	fs.StringVar(&c.sel.Name, "name", "", "glob matching task names")
+	fs.StringVar(&c.sel.State, "state", "", "comma-separated states: queued, running, succeeded, failed, killed")
+	fs.StringVar(&c.sel.Label, "label", "", "KEY=VALUE, or KEY for any value")
+	fs.StringVar(&c.sel.Worker, "worker", "", "worker running the task, or that ran its last attempt")
+}
+
+func (c *tasksBulkCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() != 1 {
+		log.Print("need exactly one action")
+		return subcommands.ExitUsageError
+	}
+	action := fs.Arg(0)
+	return printBulk(&c.formatFlags, action, func() ([]client.BulkResult, error) {
+		return cl.BulkTasks(ctx, group, &c.sel, action)
+	})
+}
+
+type workersBulkCmd struct {
+	formatFlags
+	sel    client.Selector
+	shared bool
+}
+
+func (c *workersBulkCmd) Name() string     { return "workers-bulk" }
+func (c *workersBulkCmd) Synopsis() string { return "apply an action to every matching worker" }
+func (c *workersBulkCmd) Usage() string {
+	return `bern workers-bulk [options] action
+
+Applies action to every worker in the group, or in the shared pool with -shared,
+that matches all given options. At least one of -name and -state must be given.
+Actions are reset-failures, drain and remove.
+Drained workers finish their running task but are given no new ones.
+
+`
+}
+
+func (c *workersBulkCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+	fs.StringVar(&c.sel.Name, "name", "", "glob matching worker names")
+	fs.StringVar(&c.sel.State, "state", "", "comma-separated states, e.g. Dead,Ready")
+	fs.BoolVar(&c.shared, "shared", false, "operate on the shared pool instead of the group")
+}
+
+func (c *workersBulkCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() != 1 {
+		log.Print("need exactly one action")
+		return subcommands.ExitUsageError
+	}
+	action := fs.Arg(0)
+	return printBulk(&c.formatFlags, action, func() ([]client.BulkResult, error) {
+		return cl.BulkWorkers(ctx, pool(c.shared), &c.sel, action)
+	})
+}
 
-	resp, err := http.Post(u.String(), "application/json", bytes.NewReader(b))
+// printBulk runs a bulk operation and prints one line per object,
+// or the results as JSON with -format json.
+func printBulk(f *formatFlags, action string, op func() ([]client.BulkResult, error)) subcommands.ExitStatus {
+	asJSON, err := f.json()
 	if err != nil {
-		log.Printf("unable to issue POST request: %v", err)
-		return subcommands.ExitFailure
+		log.Print(err)
+		return subcommands.ExitUsageError
 	}
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, resp.Body)
+	results, err := op()
 	if err != nil {
-		log.Printf("error while reading body: %v", err)
+		log.Print(err)
 		return subcommands.ExitFailure
 	}
-	data := make(map[string]interface{})
-	if err := json.Unmarshal(buf.Bytes(), &data); err != nil {
-		log.Printf("unable to decode response: %v", err)
-		return subcommands.ExitFailure
+	if asJSON {
+		if err := printJSON(results); err != nil {
+			log.Print(err)
+			return subcommands.ExitFailure
+		}
 	}
-
-	io.Copy(os.Stdout, &buf)
-	if v := data["success"]; v == nil || v != true {
-		return subcommands.ExitFailure
+	status := subcommands.ExitSuccess
+	for _, r := range results {
+		if !r.Success {
+			status = subcommands.ExitFailure
+		}
+		switch {
+		case asJSON:
+		case r.Success:
+			fmt.Println(action, r.Name)
+		default:
+			log.Printf("%s: %s", r.Name, r.Reason)
+		}
 	}
-
-	return subcommands.ExitSuccess
+	return status
 }
 
 type taskAddCmd struct {
-	name string
-	wd   string
+	envPolicy   string
+	name        string
+	wd          string
+	outputs     stringsFlag
+	cache       bool
+	cacheEnv    stringsFlag
+	cacheInputs stringsFlag
+	labels      stringsFlag
+	exit        exitFlags
 }
 
 func (c *taskAddCmd) Name() string     { return "task-add" }
@@ -140,14 +678,23 @@ func (c *taskAddCmd) Usage() string {
 	return `bern task-add [options] cmd args...
 
 Create a task in the group and schedule it for execution.
-The task will inherit the environment of this process (use env -i to reset this)
+The task will inherit the environment of this process (see -env)
 and will run in the current directory by default.
+Variables set on the group are given to the task as well;
+the task's own variables take precedence over the group's.
 `
 }
 
 func (c *taskAddCmd) SetFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.name, "name", "", "name to give the task, by default cmd-RANDSTR")
+	fs.StringVar(&c.envPolicy, "env", defaultEnvPolicy, envPolicyUsage)
 	fs.StringVar(&c.wd, "wd", "", "working directory for the task, empty for current dir")
+	fs.Var(&c.outputs, "output", "glob, relative to the working directory, of files to keep after each attempt (may be repeated)")
+	fs.BoolVar(&c.cache, "cache", false, "skip running if an identical task already succeeded")
+	fs.Var(&c.cacheEnv, "cacheenv", "env variable that is part of the cache key (may be repeated, implies -cache)")
+	fs.Var(&c.cacheInputs, "cacheinput", "glob, relative to the working directory, of input files that are part of the cache key (may be repeated, implies -cache)")
+	fs.Var(&c.labels, "label", "KEY=VALUE label used to select the task in bulk operations (may be repeated)")
+	c.exit.register(fs)
 }
 
 func (c *taskAddCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
@@ -155,78 +702,84 @@ func (c *taskAddCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...inte
 		log.Print("no command specified")
 		return subcommands.ExitUsageError
 	}
-
-	refURL, err := url.Parse(addr)
+	t, err := c.task(fs.Args())
 	if err != nil {
-		log.Printf("invalid addr: %v", err)
+		log.Print(err)
 		return subcommands.ExitUsageError
 	}
+	return run(func() error {
+		if err := addTask(ctx, t); err != nil {
+			return err
+		}
+		fmt.Println("added", t.Name)
+		return nil
+	})
+}
+
+// addTask adds t to the group.
+// It is an error for the group to have a task of the same name.
+func addTask(ctx context.Context, t client.Task) error {
+	added, err := cl.AddTasks(ctx, group, []client.Task{t})
+	if err != nil {
+		return err
+	}
+	if len(added.Exist) > 0 {
+		return fmt.Errorf("task %s already exists", t.Name)
+	}
+	return nil
+}
 
+// task returns the task that runs cmd as configured by c's flags.
+func (c *taskAddCmd) task(cmd []string) (client.Task, error) {
 	wd := c.wd
 	if wd == "" {
 		t, err := os.Getwd()
 		if err != nil {
-			log.Printf("unable to get working dir: %v", err)
-			return subcommands.ExitFailure
+			return client.Task{}, fmt.Errorf("unable to get working dir: %v", err)
 		}
 		wd = t
 	}
 
 	name := c.name
 	if name == "" {
-		name = fs.Arg(0) + "-" + internal.Base62(rand.Int31())
-	}
-
-	req := TasksAddReq{
-		Tasks: []Task{
-			{
-				Name: name,
-				Cmd:  fs.Args(),
-				Env:  os.Environ(),
-				WD:   wd,
-			},
-		},
-	}
-
-	b, err := json.Marshal(&req)
-	if err != nil {
-		log.Printf("unable to encode request: %v", err)
-		return subcommands.ExitFailure
+		name = cmd[0] + "-" + internal.Base62(rand.Int31())
 	}
 
-	u, err := refURL.Parse("tasks/" + group + "/add")
+	env, err := selectEnv(c.envPolicy)
 	if err != nil {
-		log.Printf("unable to construct request url: %v", err)
-		return subcommands.ExitFailure
+		return client.Task{}, err
 	}
 
-	resp, err := http.Post(u.String(), "application/json", bytes.NewReader(b))
-	if err != nil {
-		log.Printf("unable to issue POST request: %v", err)
-		return subcommands.ExitFailure
+	t := client.Task{
+		Name:    name,
+		Cmd:     cmd,
+		Env:     env,
+		WD:      wd,
+		Outputs: c.outputs,
+		Exit:    c.exit.policy(),
 	}
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, resp.Body)
-	if err != nil {
-		log.Printf("error while reading body: %v", err)
-		return subcommands.ExitFailure
-	}
-	data := make(map[string]interface{})
-	if err := json.Unmarshal(buf.Bytes(), &data); err != nil {
-		log.Printf("unable to decode response: %v", err)
-		return subcommands.ExitFailure
+	for _, l := range c.labels {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return client.Task{}, fmt.Errorf("bad label %q: want KEY=VALUE", l)
+		}
+		if t.Labels == nil {
+			t.Labels = make(map[string]string)
+		}
+		t.Labels[kv[0]] = kv[1]
 	}
-
-	io.Copy(os.Stdout, &buf)
-	if v := data["success"]; v == nil || v != true {
-		return subcommands.ExitFailure
+	if c.cache || len(c.cacheEnv) > 0 || len(c.cacheInputs) > 0 {
+		t.Cache = &client.CacheSpec{
+			Env:    c.cacheEnv,
+			Inputs: c.cacheInputs,
+		}
 	}
-
-	return subcommands.ExitSuccess
+	return t, nil
 }
 
 type workersAddCmd struct {
-	lines bool
+	lines  bool
+	shared bool
 }
 
 func (c *workersAddCmd) Name() string     { return "workers-add" }
@@ -234,7 +787,8 @@ func (c *workersAddCmd) Synopsis() string { return "add workers to a group" }
 func (c *workersAddCmd) Usage() string {
 	return `bern workers-add [options] [manifest...]
 
-Creates workers in the group.
+Creates workers in the group, or in the shared pool with -shared.
+Shared workers serve every group created with a positive -share.
 Worker manifests can be passed in as separate files or as separate lines within a file (see -lines).
 If no manifest file is passed, stdin is read.
 `
@@ -242,16 +796,11 @@ If no manifest file is passed, stdin is read.
 
 func (c *workersAddCmd) SetFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&c.lines, "lines", true, "each line within the input is a manifest")
+	fs.BoolVar(&c.shared, "shared", false, "add workers to the shared pool instead of the group")
 }
 
 func (c *workersAddCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
-	refURL, err := url.Parse(addr)
-	if err != nil {
-		log.Printf("invalid addr: %v", err)
-		return subcommands.ExitUsageError
-	}
-
-	var ws []Worker
+	var manifests []string
 	rcs := []io.ReadCloser{os.Stdin}
 	if fs.NArg() > 0 {
 		rcs = make([]io.ReadCloser, fs.NArg())
@@ -271,9 +820,9 @@ func (c *workersAddCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...i
 		}
 		s := bufio.NewScanner(io.MultiReader(rs...))
 		for s.Scan() {
-			ws = append(ws, Worker{Manifest: s.Text()})
+			manifests = append(manifests, s.Text())
 		}
-		if s.Err() != nil {
+		if err := s.Err(); err != nil {
 			log.Printf("error while reading manifests: %v", err)
 			return subcommands.ExitFailure
 		}
@@ -284,64 +833,69 @@ func (c *workersAddCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...i
 				log.Printf("error while reading manifests: %v", err)
 				return subcommands.ExitFailure
 			}
-			ws = append(ws, Worker{Manifest: string(b)})
+			manifests = append(manifests, string(b))
 		}
 	}
 	for _, c := range rcs {
 		c.Close()
 	}
 
-	req := WorkersAddReq{Workers: ws}
-	b, err := json.Marshal(&req)
-	if err != nil {
-		log.Printf("unable to encode request: %v", err)
-		return subcommands.ExitFailure
-	}
-
-	u, err := refURL.Parse("workers/" + group + "/add")
-	if err != nil {
-		log.Printf("unable to construct request url: %v", err)
-		return subcommands.ExitFailure
-	}
-
-	resp, err := http.Post(u.String(), "application/json", bytes.NewReader(b))
-	if err != nil {
-		log.Printf("unable to issue POST request: %v", err)
-		return subcommands.ExitFailure
-	}
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, resp.Body)
-	if err != nil {
-		log.Printf("error while reading body: %v", err)
-		return subcommands.ExitFailure
-	}
-	data := make(map[string]interface{})
-	if err := json.Unmarshal(buf.Bytes(), &data); err != nil {
-		log.Printf("unable to decode response: %v", err)
-		return subcommands.ExitFailure
-	}
-
-	io.Copy(os.Stdout, &buf)
-	if v := data["success"]; v == nil || v != true {
-		return subcommands.ExitFailure
-	}
-
-	return subcommands.ExitSuccess
+	return run(func() error { return cl.AddWorkers(ctx, pool(c.shared), manifests) })
 }
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
 	flag.StringVar(&group, "group", "default", "group to operate in")
 	flag.StringVar(&addr, "addr", "http://127.0.0.1:8080", "address of bernie server")
+	flag.StringVar(&token, "token", "", "bearer token to send with requests")
+	profile := flag.String("profile", "", "profile in the config file to use (default $BERN_PROFILE, or the config's default)")
 	log.SetPrefix("bern: ")
 	log.SetFlags(0)
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
 	subcommands.Register(new(groupAddCmd), "")
+	subcommands.Register(new(groupRmCmd), "")
+	subcommands.Register(new(groupSetCmd), "")
+	subcommands.Register(new(groupInitCmd), "")
+	subcommands.Register(new(groupPauseCmd), "")
+	subcommands.Register(new(groupListCmd), "")
+	subcommands.Register(new(groupResumeCmd), "")
+	subcommands.Register(new(webhookAddCmd), "")
+	subcommands.Register(new(webhookRmCmd), "")
+	subcommands.Register(new(webhookListCmd), "")
 	subcommands.Register(new(taskAddCmd), "")
+	subcommands.Register(new(tasksSubmitCmd), "")
+	subcommands.Register(new(runCmd), "")
+	subcommands.Register(new(waitCmd), "")
+	subcommands.Register(new(attachCmd), "")
+	subcommands.Register(new(taskListCmd), "")
+	subcommands.Register(new(taskStatusCmd), "")
+	subcommands.Register(new(taskOutCmd), "")
+	subcommands.Register(new(taskRmCmd), "")
+	subcommands.Register(new(taskResetCmd), "")
+	subcommands.Register(&taskSuspendCmd{resume: false}, "")
+	subcommands.Register(&taskSuspendCmd{resume: true}, "")
+	subcommands.Register(new(taskRerunCmd), "")
+	subcommands.Register(new(taskCloneCmd), "")
+	subcommands.Register(new(tasksBulkCmd), "")
 	subcommands.Register(new(workersAddCmd), "")
+	subcommands.Register(new(workersBulkCmd), "")
+	subcommands.Register(new(workerListCmd), "")
+	subcommands.Register(new(workerRmCmd), "")
+	subcommands.Register(new(workerResetCmd), "")
+	subcommands.Register(new(workerInitOutCmd), "")
 
 	flag.Parse()
+	if err := applyProfile(*profile); err != nil {
+		log.Print(err)
+		os.Exit(int(subcommands.ExitUsageError))
+	}
+	var err error
+	if cl, err = client.New(addr); err != nil {
+		log.Print(err)
+		os.Exit(int(subcommands.ExitUsageError))
+	}
+	cl.Token = token
 	os.Exit(int(subcommands.Execute(context.Background())))
 }
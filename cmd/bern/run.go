@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/uluyol/bernie/client"
+)
+
+// exitInterrupted is the exit status of run when it stops following the task on interrupt.
+const exitInterrupted = 130
+
+type runCmd struct {
+	taskAddCmd
+	poll time.Duration
+}
+
+func (c *runCmd) Name() string     { return "run" }
+func (c *runCmd) Synopsis() string { return "run a task and follow its output" }
+func (c *runCmd) Usage() string {
+	return `bern run [options] cmd args...
+
+Create a task as task-add does and print its output until it finishes.
+Exits with the exit code of the task's last attempt,
+or 1 if the task failed without exiting with a failing code.
+On interrupt, asks whether to kill the task or leave it running.
+`
+}
+
+func (c *runCmd) SetFlags(fs *flag.FlagSet) {
+	c.taskAddCmd.SetFlags(fs)
+	fs.DurationVar(&c.poll, "poll", time.Second, "how often to check on the task")
+}
+
+func (c *runCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() == 0 {
+		log.Print("no command specified")
+		return subcommands.ExitUsageError
+	}
+	t, err := c.task(fs.Args())
+	if err != nil {
+		log.Print(err)
+		return subcommands.ExitUsageError
+	}
+	if err := addTask(ctx, t); err != nil {
+		log.Print(err)
+		return subcommands.ExitFailure
+	}
+	log.Printf("added task %s", t.Name)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	tick := time.NewTicker(c.poll)
+	defer tick.Stop()
+
+	f := follower{name: t.Name}
+	for {
+		done, code, err := f.step(ctx)
+		if err != nil {
+			log.Print(err)
+			return subcommands.ExitFailure
+		}
+		if done {
+			return subcommands.ExitStatus(code)
+		}
+		select {
+		case <-tick.C:
+		case <-sigs:
+			return interrupted(ctx, t.Name, sigs)
+		}
+	}
+}
+
+// interrupted asks whether to kill the named task and does so if told to.
+// Another interrupt while asking leaves the task running.
+func interrupted(ctx context.Context, name string, sigs chan os.Signal) subcommands.ExitStatus {
+	fmt.Fprintf(os.Stderr, "\nkill task %s? [y/N] ", name)
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer <- strings.TrimSpace(line)
+	}()
+	select {
+	case a := <-answer:
+		if a == "y" || a == "Y" {
+			rs, err := cl.BulkTasks(ctx, group, &client.Selector{Name: globQuote(name)}, client.ActionKill)
+			if err == nil && len(rs) > 0 && !rs[0].Success {
+				err = errors.New(rs[0].Reason)
+			}
+			if err != nil {
+				log.Printf("unable to kill task: %v", err)
+				return subcommands.ExitFailure
+			}
+			log.Printf("killed task %s", name)
+			return exitInterrupted
+		}
+	case <-sigs:
+		fmt.Fprintln(os.Stderr)
+	}
+	log.Printf("left task %s running", name)
+	return exitInterrupted
+}
+
+// globQuote escapes the characters of s that are special in globs.
+func globQuote(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// follower prints the output of a task as it runs.
+type follower struct {
+	name    string
+	attempt int // the attempt whose output is being printed, from 1
+	printed int // lines of the attempt's output that were printed
+}
+
+// step prints the task's new output.
+// Once the task has finished, it returns true and the exit code of its last attempt,
+// even if the task's exit policy counted it as success.
+func (f *follower) step(ctx context.Context) (done bool, code int, err error) {
+	t, err := cl.Task(ctx, group, f.name)
+	if err != nil {
+		return false, 0, err
+	}
+	attempts := t.Status.Attempts
+	running := t.Status.State == client.Running
+	done = t.Status.Finished()
+
+	attempt := len(attempts)
+	if running {
+		attempt++
+	}
+	if attempt != f.attempt {
+		if f.attempt > 0 && f.attempt <= len(attempts) {
+			a := attempts[f.attempt-1]
+			log.Printf("attempt %d failed on %s: %s", f.attempt, a.Worker, a.Err)
+		}
+		f.attempt = attempt
+		f.printed = 0
+	}
+	if !running && !done {
+		return false, 0, nil
+	}
+
+	out, err := cl.TaskOutput(ctx, group, f.name)
+	if err != nil {
+		return false, 0, err
+	}
+	lines := outputLines(out)
+	if running && len(lines) > 0 {
+		// The last line may not be complete yet.
+		lines = lines[:len(lines)-1]
+	}
+	for ; f.printed < len(lines); f.printed++ {
+		fmt.Println(lines[f.printed])
+	}
+	if !done {
+		return false, 0, nil
+	}
+	code = -1
+	if len(attempts) > 0 {
+		code = attempts[len(attempts)-1].ExitCode
+	}
+	if t.Status.State == client.Succeeded {
+		if code < 0 {
+			code = 0
+		}
+		return true, code, nil
+	}
+	if code <= 0 {
+		// The task failed without a failing exit code of its own.
+		code = 1
+	}
+	return true, code, nil
+}
+
+// outputLines splits the output of a task into lines,
+// dropping the blank lines and notice that tmux adds after it.
+func outputLines(out string) []string {
+	out = strings.TrimRight(out, " \n")
+	if i := strings.LastIndexByte(out, '\n'); strings.HasPrefix(out[i+1:], "Pane is dead") {
+		out = strings.TrimRight(out[:i+1], " \n")
+	}
+	if out == "" {
+		return nil
+	}
+	return strings.Split(out, "\n")
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+
+	"github.com/google/subcommands"
+	"github.com/uluyol/bernie/client"
+	"github.com/uluyol/bernie/internal"
+)
+
+// maxTaskLine is the longest line tasks-submit accepts.
+const maxTaskLine = 16 << 20
+
+type tasksSubmitCmd struct {
+	formatFlags
+	chunk int
+}
+
+func (c *tasksSubmitCmd) Name() string     { return "tasks-submit" }
+func (c *tasksSubmitCmd) Synopsis() string { return "create tasks from a JSON Lines file" }
+func (c *tasksSubmitCmd) Usage() string {
+	return `bern tasks-submit [options] [file.jsonl]
+
+Create a task in the group for each line of the file, or of stdin if no file is given.
+Each line is a JSON task object with cmd and optionally name, env, wd, outputs,
+cache, exit and labels, as sent by task-add.
+Tasks without a name are named cmd-RANDSTR, and those without a wd
+run in the current directory. Blank lines are skipped.
+Prints the tasks that were added and those that already existed.
+
+`
+}
+
+func (c *tasksSubmitCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+	fs.IntVar(&c.chunk, "chunk", 1000, "max tasks to send per request")
+}
+
+func (c *tasksSubmitCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if fs.NArg() > 1 {
+		log.Print("need at most one file")
+		return subcommands.ExitUsageError
+	}
+	if c.chunk <= 0 {
+		log.Print("-chunk must be positive")
+		return subcommands.ExitUsageError
+	}
+	asJSON, err := c.json()
+	if err != nil {
+		log.Print(err)
+		return subcommands.ExitUsageError
+	}
+
+	in, inName := io.Reader(os.Stdin), "stdin"
+	if fs.NArg() == 1 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			log.Print(err)
+			return subcommands.ExitFailure
+		}
+		defer f.Close()
+		in, inName = f, fs.Arg(0)
+	}
+	ts, err := readTasks(in)
+	if err != nil {
+		log.Printf("%s: %v", inName, err)
+		return subcommands.ExitFailure
+	}
+	if len(ts) == 0 {
+		log.Printf("%s: no tasks", inName)
+		return subcommands.ExitFailure
+	}
+
+	var all client.AddedTasks
+	status := subcommands.ExitSuccess
+	for len(ts) > 0 {
+		n := c.chunk
+		if n > len(ts) {
+			n = len(ts)
+		}
+		resp, err := cl.AddTasks(ctx, group, ts[:n])
+		if err != nil {
+			// The request failed outright; report what earlier chunks did.
+			log.Print(err)
+			if len(all.Added) == 0 && len(all.Exist) == 0 {
+				return subcommands.ExitFailure
+			}
+			status = subcommands.ExitFailure
+			break
+		}
+		all.Added = append(all.Added, resp.Added...)
+		all.Exist = append(all.Exist, resp.Exist...)
+		ts = ts[n:]
+	}
+	if len(all.Exist) > 0 {
+		status = subcommands.ExitFailure
+	}
+
+	if asJSON {
+		if all.Added == nil {
+			all.Added = []string{}
+		}
+		if all.Exist == nil {
+			all.Exist = []string{}
+		}
+		printJSON(&all)
+		return status
+	}
+	for _, name := range all.Added {
+		fmt.Println("added", name)
+	}
+	for _, name := range all.Exist {
+		fmt.Println("exists", name)
+	}
+	fmt.Printf("%d added, %d already existed\n", len(all.Added), len(all.Exist))
+	return status
+}
+
+// readTasks reads one task per line from r and fills in their names and working dirs.
+func readTasks(r io.Reader) ([]client.Task, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get working dir: %v", err)
+	}
+	var ts []client.Task
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxTaskLine)
+	for lineno := 1; s.Scan(); lineno++ {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		d := json.NewDecoder(bytes.NewReader(line))
+		d.DisallowUnknownFields()
+		var t client.Task
+		if err := d.Decode(&t); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		if len(t.Cmd) == 0 {
+			return nil, fmt.Errorf("line %d: no cmd", lineno)
+		}
+		if t.Name == "" {
+			t.Name = t.Cmd[0] + "-" + internal.Base62(rand.Int31())
+		}
+		if t.WD == "" {
+			t.WD = wd
+		}
+		ts = append(ts, t)
+	}
+	return ts, s.Err()
+}
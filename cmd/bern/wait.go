@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/uluyol/bernie/client"
+)
+
+const (
+	// waitPoll is how many seconds the server is asked to wait per request.
+	waitPoll = 30
+	// minWait is how many seconds the server is asked to wait
+	// once the deadline has passed.
+	minWait = 0.001
+)
+
+type waitCmd struct {
+	formatFlags
+	sel     client.Selector
+	timeout time.Duration
+}
+
+func (c *waitCmd) Name() string     { return "wait" }
+func (c *waitCmd) Synopsis() string { return "wait for tasks to finish" }
+func (c *waitCmd) Usage() string {
+	return `bern wait [options] [task...]
+
+Waits until the named tasks, and those matching -name and -label,
+have succeeded, failed with no tries left, or been killed.
+Prints a summary and exits with a nonzero status if any task failed or was killed.
+
+`
+}
+
+func (c *waitCmd) SetFlags(fs *flag.FlagSet) {
+	c.formatFlags.register(fs)
+	fs.StringVar(&c.sel.Name, "name", "", "glob matching task names")
+	fs.StringVar(&c.sel.Label, "label", "", "KEY=VALUE, or KEY for any value")
+	fs.DurationVar(&c.timeout, "timeout", 0, "give up after this long, 0 to wait forever")
+}
+
+func (c *waitCmd) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	req := client.Wait{Names: fs.Args()}
+	if c.sel != (client.Selector{}) {
+		req.Selector = &c.sel
+	}
+	if len(req.Names) == 0 && req.Selector == nil {
+		log.Print("no tasks specified")
+		return subcommands.ExitUsageError
+	}
+	asJSON, err := c.json()
+	if err != nil {
+		log.Print(err)
+		return subcommands.ExitUsageError
+	}
+
+	var deadline time.Time
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	var resp *client.Waited
+	for {
+		req.Timeout = waitPoll
+		if !deadline.IsZero() {
+			left := time.Until(deadline).Seconds()
+			if left <= 0 && resp != nil {
+				break
+			}
+			if left <= 0 {
+				// Ask once anyway so that the tasks can be reported.
+				// A timeout of 0 would get the server's default.
+				left = minWait
+			}
+			if left < waitPoll {
+				req.Timeout = left
+			}
+		}
+		var err error
+		if resp, err = cl.WaitTasks(ctx, group, &req); err != nil {
+			log.Print(err)
+			return subcommands.ExitFailure
+		}
+		if resp.Done {
+			break
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, t := range resp.Tasks {
+		counts[t.State]++
+	}
+	if asJSON {
+		printJSON(resp)
+	} else {
+		tw := newTable()
+		fmt.Fprintln(tw, "NAME\tSTATE\tEXIT\tERR")
+		for _, t := range resp.Tasks {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", t.Name, t.State, t.ExitCode, dash(t.Err))
+		}
+		tw.Flush()
+		fmt.Printf("%d succeeded, %d failed, %d killed",
+			counts[client.Succeeded], counts[client.Failed], counts[client.Killed])
+		if n := len(resp.Tasks) - counts[client.Succeeded] - counts[client.Failed] - counts[client.Killed]; n > 0 {
+			fmt.Printf(", %d not finished", n)
+		}
+		fmt.Println()
+	}
+	if !resp.Done {
+		log.Print("timed out")
+		return subcommands.ExitFailure
+	}
+	if counts[client.Failed] > 0 || counts[client.Killed] > 0 {
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// route is an endpoint of the API.
+// Each is served under /v1 and, unless legacy is "-", at its unversioned path as well.
+//
+// The v1 routes reply to errors with an apiErrorResp and use the status of the route
+// on success. The unversioned routes keep their old replies: {"success": false, "reason": ...}
+// on errors, or plain text for routes that return text, and "success": true added to
+// successful replies.
+type route struct {
+	method string
+	path   string
+	// legacy is the unversioned path of the route if it differs from path,
+	// or "-" if there is none.
+	legacy  string
+	handle  func(*handler, http.ResponseWriter, *http.Request)
+	summary string
+	// query names the query parameters of the route, as described in queryParams.
+	query []string
+	// req is a value of the type of the JSON request body, or nil if there is none.
+	req interface{}
+	// optional is set if the request body may be left out.
+	optional bool
+	// status is the status of successful v1 replies, or 200 if 0.
+	status int
+	// resp is a value of the type of successful JSON replies, or nil if they have no body.
+	// Replies that are not JSON are given by their content type.
+	resp interface{}
+}
+
+var queryParams = map[string]struct {
+	typ, desc string
+}{
+	"prefix":  {"string", "only list objects whose names start with this"},
+	"state":   {"string", "comma-separated states to list objects in"},
+	"limit":   {"integer", "most objects to list, up to 1000"},
+	"cursor":  {"string", "next of the previous page"},
+	"attempt": {"integer", "only include this attempt"},
+}
+
+var listQueryParams = []string{"prefix", "state", "limit", "cursor"}
+
+var routes = []route{
+	{method: "GET", path: "/metrics", handle: (*handler).metricsHandler,
+		summary: "Get metrics in the Prometheus text format", resp: "text/plain"},
+	{method: "GET", path: "/openapi.json", legacy: "-", handle: (*handler).openAPIHandler,
+		summary: "Get this description of the API", resp: "application/json"},
+
+	{method: "GET", path: "/groups", handle: (*handler).groupsListHandler,
+		summary: "List groups", query: listQueryParams, resp: groupsListResp{}},
+	{method: "POST", path: "/groups", legacy: "/groups/add", handle: (*handler).groupsAddHandler,
+		summary: "Create a group", req: groupsAddReq{}, status: http.StatusCreated},
+	{method: "GET", path: "/groups/{group}", handle: (*handler).groupsGetHandler,
+		summary: "Describe a group", resp: groupView{}},
+	{method: "DELETE", path: "/groups/{group}", handle: (*handler).groupsDeleteHandler,
+		summary: "Kill the tasks of a group, remove its workers, and remove it", status: http.StatusNoContent},
+	{method: "PATCH", path: "/groups/{group}", handle: (*handler).groupsPatchHandler,
+		summary: "Change the limits of a group", req: groupsPatchReq{}, status: http.StatusNoContent},
+	{method: "POST", path: "/groups/{group}/init", handle: (*handler).groupsInitHandler,
+		summary: "Replace the init task of a group", req: groupsInitReq{}, resp: groupsInitResp{}},
+	{method: "GET", path: "/groups/{group}/pause", handle: (*handler).groupsPausedHandler,
+		summary: "Tell whether a group is paused", resp: pauseView{}},
+	{method: "POST", path: "/groups/{group}/pause", handle: (*handler).groupsPauseHandler,
+		summary: "Stop a group from starting queued tasks", req: groupsPauseReq{}, optional: true, status: http.StatusNoContent},
+	{method: "POST", path: "/groups/{group}/resume", handle: (*handler).groupsResumeHandler,
+		summary: "Let a paused group start queued tasks", status: http.StatusNoContent},
+	{method: "POST", path: "/groups/{group}/secrets", handle: (*handler).groupsSecretsHandler,
+		summary: "Add or replace secrets of a group", req: secretsReq{}, status: http.StatusNoContent},
+	{method: "DELETE", path: "/groups/{group}/secrets/{key}", handle: (*handler).groupsSecretDeleteHandler,
+		summary: "Remove a secret of a group", status: http.StatusNoContent},
+	{method: "GET", path: "/groups/{group}/webhooks", handle: (*handler).webhooksListHandler,
+		summary: "List the webhooks of a group and their recent deliveries", resp: webhooksListResp{}},
+	{method: "POST", path: "/groups/{group}/webhooks", handle: (*handler).webhooksAddHandler,
+		summary: "Send the events of a group to a url", req: webhookReq{}, status: http.StatusCreated, resp: webhooksAddResp{}},
+	{method: "DELETE", path: "/groups/{group}/webhooks/{id}", handle: (*handler).webhooksDeleteHandler,
+		summary: "Remove a webhook of a group", status: http.StatusNoContent},
+
+	{method: "GET", path: "/tasks/{group}", handle: (*handler).tasksListHandler,
+		summary: "List the tasks of a group", query: listQueryParams, resp: tasksListResp{}},
+	{method: "POST", path: "/tasks/{group}", legacy: "/tasks/{group}/add", handle: (*handler).tasksAddHandler,
+		summary: "Add tasks to a group, replying 200 instead if some already existed", req: tasksAddReq{}, status: http.StatusCreated, resp: tasksAddResp{}},
+	{method: "POST", path: "/tasks/{group}/bulk", handle: (*handler).tasksBulkHandler,
+		summary: "Apply an action to every matching task", req: bulkReq{}, resp: bulkResp{}},
+	{method: "POST", path: "/tasks/{group}/wait", handle: (*handler).tasksWaitHandler,
+		summary: "Wait for tasks to finish", req: waitReq{}, resp: waitResp{}},
+	{method: "GET", path: "/tasks/{group}/{task}", handle: (*handler).tasksGetHandler,
+		summary: "Describe a task", resp: taskView{}},
+	{method: "DELETE", path: "/tasks/{group}/{task}", handle: (*handler).tasksDeleteHandler,
+		summary: "Kill and remove a task", status: http.StatusNoContent},
+	{method: "PATCH", path: "/tasks/{group}/{task}", handle: (*handler).tasksPatchHandler,
+		summary: "Reset the tries of a task", req: tasksPatchReq{}, status: http.StatusNoContent},
+	{method: "GET", path: "/tasks/{group}/{task}/manifest", handle: (*handler).tasksManifestHandler,
+		summary: "Describe a task and the settings of its group", resp: taskManifestView{}},
+	{method: "POST", path: "/tasks/{group}/{task}/rerun", handle: (*handler).tasksRerunHandler,
+		summary: "Queue a finished or killed task again", status: http.StatusNoContent},
+	{method: "POST", path: "/tasks/{group}/{task}/clone", handle: (*handler).tasksCloneHandler,
+		summary: "Add a copy of a task", req: cloneOpts{}, status: http.StatusCreated, resp: tasksCloneResp{}},
+	{method: "POST", path: "/tasks/{group}/{task}/suspend", handle: (*handler).tasksSuspendHandler,
+		summary: "Stop a running task with SIGSTOP", status: http.StatusNoContent},
+	{method: "POST", path: "/tasks/{group}/{task}/resume", handle: (*handler).tasksSuspendHandler,
+		summary: "Continue a suspended task", status: http.StatusNoContent},
+	{method: "GET", path: "/tasks/{group}/{task}/out", handle: (*handler).tasksOutHandler,
+		summary: "Get the output of a task", resp: "text/plain"},
+	{method: "GET", path: "/tasks/{group}/{task}/session", handle: (*handler).sessionHandler,
+		summary: "Get the tmux session of the last attempt of a task", resp: sessionView{}},
+	{method: "GET", path: "/tasks/{group}/{task}/artifacts", handle: (*handler).tasksArtifactsHandler,
+		summary: "List the files kept from the attempts of a task", resp: artifactsResp{}},
+	{method: "GET", path: "/tasks/{group}/{task}/artifacts.tar", handle: (*handler).tasksArtifactsTarHandler,
+		summary: "Get the files kept from a task as a tar archive", query: []string{"attempt"}, resp: "application/x-tar"},
+	{method: "GET", path: "/tasks/{group}/{task}/artifacts/{attempt}/{path:.+}", handle: (*handler).tasksArtifactHandler,
+		summary: "Get a file kept from an attempt of a task", resp: "application/octet-stream"},
+}
+
+func init() {
+	// The routes of group workers and shared workers only differ in their paths.
+	for _, p := range []string{"/workers/{group}", "/shared/workers"} {
+		routes = append(routes, []route{
+			{method: "GET", path: p, handle: (*handler).workersListHandler,
+				summary: "List workers", query: listQueryParams, resp: workersListResp{}},
+			{method: "POST", path: p, legacy: p + "/add", handle: (*handler).workersAddHandler,
+				summary: "Add workers", req: workersAddReq{}, status: http.StatusCreated},
+			{method: "POST", path: p + "/bulk", handle: (*handler).workersBulkHandler,
+				summary: "Apply an action to every matching worker", req: bulkReq{}, resp: bulkResp{}},
+			{method: "GET", path: p + "/{worker}", handle: (*handler).workersGetHandler,
+				summary: "Describe a worker", resp: workerView{}},
+			{method: "DELETE", path: p + "/{worker}", handle: (*handler).workersDeleteHandler,
+				summary: "Remove a worker", status: http.StatusNoContent},
+			{method: "PATCH", path: p + "/{worker}", handle: (*handler).workersPatchHandler,
+				summary: "Reset the failure count of a worker", req: workersPatchReq{}, status: http.StatusNoContent},
+			{method: "GET", path: p + "/{worker}/manifest", handle: (*handler).workersManifestHandler,
+				summary: "Get the manifest of a worker", resp: "text/plain"},
+			{method: "GET", path: p + "/{worker}/initout", handle: (*handler).workersInitOutHandler,
+				summary: "Get the output of the init task of a worker", resp: "text/plain"},
+			{method: "GET", path: p + "/{worker}/session", handle: (*handler).sessionHandler,
+				summary: "Get the tmux session of the init task of a worker", resp: sessionView{}},
+		}...)
+	}
+}
+
+type apiKey int
+
+// v1Key marks the contexts of requests to v1 routes.
+const v1Key apiKey = 0
+
+func isV1(r *http.Request) bool {
+	return r.Context().Value(v1Key) != nil
+}
+
+// register adds the routes to r.
+func (s *handler) register(r *mux.Router) {
+	s.openapi = openAPI()
+	for _, rt := range routes {
+		handle := rt.handle
+		f := func(w http.ResponseWriter, r *http.Request) { handle(s, w, r) }
+		r.HandleFunc("/v1"+rt.path, func(w http.ResponseWriter, r *http.Request) {
+			f(w, r.WithContext(context.WithValue(r.Context(), v1Key, true)))
+		}).Methods(rt.method)
+		switch rt.legacy {
+		case "-":
+		case "":
+			r.HandleFunc(rt.path, f).Methods(rt.method)
+		default:
+			r.HandleFunc(rt.legacy, f).Methods(rt.method)
+		}
+	}
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/v1/") {
+			http.NotFound(w, r)
+			return
+		}
+		s.fail(w, r.WithContext(context.WithValue(r.Context(), v1Key, true)), codeNotFound,
+			fmt.Sprintf("no route for %s %s", r.Method, r.URL.Path))
+	})
+}
+
+// Error codes of the v1 API.
+const (
+	codeInvalid  = "invalid_request"
+	codeNotFound = "not_found"
+	codeExists   = "already_exists"
+	// codeConflict is returned when the state of an object does not allow an operation,
+	// such as suspending a task that is not running.
+	codeConflict = "conflict"
+	codeInternal = "internal"
+)
+
+var codeStatus = map[string]int{
+	codeInvalid:  http.StatusBadRequest,
+	codeNotFound: http.StatusNotFound,
+	codeExists:   http.StatusConflict,
+	codeConflict: http.StatusConflict,
+	codeInternal: http.StatusInternalServerError,
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details, if set, is an object with more about the error,
+	// such as the names of the tasks that do not exist.
+	Details interface{} `json:"details,omitempty"`
+}
+
+type apiErrorResp struct {
+	Error apiError `json:"error"`
+}
+
+// fail replies to r with an error.
+func (s *handler) fail(w http.ResponseWriter, r *http.Request, code, msg string) {
+	s.failDetails(w, r, code, msg, nil)
+}
+
+// failInternal replies to r with an internal error.
+// Legacy replies do not give msg, as they never did.
+func (s *handler) failInternal(w http.ResponseWriter, r *http.Request, msg string) {
+	if !isV1(r) {
+		msg = ""
+	}
+	s.fail(w, r, codeInternal, msg)
+}
+
+// failDetails is like fail but also gives details in v1 replies.
+func (s *handler) failDetails(w http.ResponseWriter, r *http.Request, code, msg string, details interface{}) {
+	status := codeStatus[code]
+	if !isV1(r) && w.Header().Get("content-type") == "text/plain" {
+		w.WriteHeader(status)
+		fmt.Fprintln(w, msg)
+		return
+	}
+	var v interface{} = apiErrorResp{apiError{code, msg, details}}
+	if !isV1(r) {
+		v = struct {
+			Success bool   `json:"success"`
+			Reason  string `json:"reason,omitempty"`
+		}{false, msg}
+	}
+	s.writeJSONStatus(w, r, status, v)
+}
+
+// reply writes v, or nothing if it is nil, as the successful reply to r.
+// v1 replies have status, and legacy replies have status 200 and "success": true added to v.
+func (s *handler) reply(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	if isV1(r) {
+		if v == nil {
+			w.WriteHeader(status)
+			return
+		}
+		s.writeJSONStatus(w, r, status, v)
+		return
+	}
+	m := map[string]interface{}{"success": true}
+	if v != nil {
+		b, err := json.Marshal(v)
+		var fields map[string]json.RawMessage
+		if err == nil {
+			err = json.Unmarshal(b, &fields)
+		}
+		if err != nil {
+			s.fail(w, r, codeInternal, "unable to encode response")
+			return
+		}
+		for k, f := range fields {
+			m[k] = f
+		}
+	}
+	s.writeJSON(w, r, m)
+}
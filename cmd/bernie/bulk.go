@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/uluyol/bernie"
+)
+
+// selector picks tasks or workers for a bulk operation.
+// Each set field must match; at least one must be set.
+type selector struct {
+	// Name is a glob, as in path.Match, on the name.
+	Name string `json:"name"`
+	// State is a comma-separated list of states,
+	// as reported by the listing endpoints.
+	State string `json:"state"`
+	// Label is KEY=VALUE, or KEY to match any value. Tasks only.
+	Label string `json:"label"`
+	// Worker is the name of the worker that is running the task,
+	// or that ran its last attempt. Tasks only.
+	Worker string `json:"worker"`
+}
+
+var errEmptySelector = errors.New("selector must set at least one field")
+
+func (sel *selector) validate(forTasks bool) error {
+	if sel.Name == "" && sel.State == "" && sel.Label == "" && sel.Worker == "" {
+		return errEmptySelector
+	}
+	if !forTasks && (sel.Label != "" || sel.Worker != "") {
+		return errors.New("workers cannot be selected by label or worker")
+	}
+	if _, err := path.Match(sel.Name, ""); err != nil {
+		return fmt.Errorf("bad name pattern: %v", err)
+	}
+	return nil
+}
+
+func (sel *selector) matches(name, state string) bool {
+	if sel.Name != "" {
+		if ok, _ := path.Match(sel.Name, name); !ok {
+			return false
+		}
+	}
+	if sel.State != "" {
+		found := false
+		for _, s := range strings.Split(sel.State, ",") {
+			if strings.EqualFold(s, state) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (sel *selector) matchesTask(t *bernie.Task, maxTries int) bool {
+	st := t.Status()
+	if !sel.matches(t.Name, taskState(st, maxTries)) {
+		return false
+	}
+	if sel.Label != "" {
+		kv := strings.SplitN(sel.Label, "=", 2)
+		v, ok := t.Labels[kv[0]]
+		if !ok || (len(kv) == 2 && v != kv[1]) {
+			return false
+		}
+	}
+	if sel.Worker != "" {
+		runner := ""
+		if st.Runner != nil {
+			runner = st.Runner.Name()
+		}
+		if a := st.LastAttempt(); runner == "" && a != nil {
+			runner = a.Worker
+		}
+		if runner != sel.Worker {
+			return false
+		}
+	}
+	return true
+}
+
+type bulkReq struct {
+	Selector selector `json:"selector"`
+	Action   string   `json:"action"`
+}
+
+type bulkResp struct {
+	Results []bulkResult `json:"results"`
+}
+
+type bulkResult struct {
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func newBulkResult(name string, err error) bulkResult {
+	r := bulkResult{Name: name, Success: err == nil}
+	if err != nil {
+		r.Reason = err.Error()
+	}
+	return r
+}
+
+// Possible paths:
+// /tasks/{group}/bulk
+func (s *handler) tasksBulkHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	var reqData bulkReq
+	if !s.decodeBodyInto(w, r, &reqData) {
+		return
+	}
+	p := s.bernie.pool(group)
+	if p == nil {
+		s.fail(w, r, codeNotFound, errGroupNotExist.Error())
+		return
+	}
+	var act func(*bernie.Task) error
+	switch reqData.Action {
+	case "kill":
+		act = func(t *bernie.Task) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			t.Kill(ctx, false)
+			return ctx.Err()
+		}
+	case "reset-tries":
+		act = func(t *bernie.Task) error {
+			t.ResetTries()
+			return nil
+		}
+	case "rerun":
+		act = func(t *bernie.Task) error {
+			return s.bernie.rerunTask(group, t.Name)
+		}
+	case "delete":
+		act = func(t *bernie.Task) error {
+			return s.bernie.rmTask(group, t.Name)
+		}
+	default:
+		s.fail(w, r, codeInvalid, "unknown action "+reqData.Action)
+		return
+	}
+	if err := reqData.Selector.validate(true); err != nil {
+		s.fail(w, r, codeInvalid, err.Error())
+		return
+	}
+
+	maxTries := p.AllowableTaskTries()
+	resp := bulkResp{[]bulkResult{}}
+	allOK := true
+	for _, t := range s.bernie.Tasks(group) {
+		if !reqData.Selector.matchesTask(t, maxTries) {
+			continue
+		}
+		res := newBulkResult(t.Name, act(t))
+		allOK = allOK && res.Success
+		resp.Results = append(resp.Results, res)
+	}
+	s.log.WithFields(logrus.Fields{
+		"group":  group,
+		"action": reqData.Action,
+		"tasks":  len(resp.Results),
+	}).Info("bulk task operation")
+	s.replyBulk(w, r, &resp, allOK)
+}
+
+// Possible paths:
+// /workers/{group}/bulk
+// /shared/workers/bulk
+func (s *handler) workersBulkHandler(w http.ResponseWriter, r *http.Request) {
+	group, hasGroup := mux.Vars(r)["group"]
+	var reqData bulkReq
+	if !s.decodeBodyInto(w, r, &reqData) {
+		return
+	}
+	p, ok := s.workersPool(w, r)
+	if !ok {
+		return
+	}
+	var act func(*bernie.Worker) error
+	switch reqData.Action {
+	case "reset-failures":
+		act = func(wk *bernie.Worker) error {
+			s.resetFailures(r, wk)
+			return nil
+		}
+	case "drain":
+		act = func(wk *bernie.Worker) error {
+			wk.Drain()
+			return nil
+		}
+	case "remove":
+		act = func(wk *bernie.Worker) error {
+			if !hasGroup {
+				s.bernie.rmSharedWorker(wk.Name())
+			} else if !s.bernie.rmWorker(group, wk.Name()) {
+				return errGroupNotExist
+			}
+			return nil
+		}
+	default:
+		s.fail(w, r, codeInvalid, "unknown action "+reqData.Action)
+		return
+	}
+	if err := reqData.Selector.validate(false); err != nil {
+		s.fail(w, r, codeInvalid, err.Error())
+		return
+	}
+
+	resp := bulkResp{[]bulkResult{}}
+	allOK := true
+	for _, wk := range s.workers(r) {
+		if !reqData.Selector.matches(wk.Name(), s.newWorkerView(p, wk).State) {
+			continue
+		}
+		res := newBulkResult(wk.Name(), act(wk))
+		allOK = allOK && res.Success
+		resp.Results = append(resp.Results, res)
+	}
+	s.log.WithFields(logrus.Fields{
+		"group":   group,
+		"action":  reqData.Action,
+		"workers": len(resp.Results),
+	}).Info("bulk worker operation")
+	s.replyBulk(w, r, &resp, allOK)
+}
+
+// replyBulk replies with the results of a bulk operation.
+// Legacy replies have success set only if the operation succeeded on every object.
+func (s *handler) replyBulk(w http.ResponseWriter, r *http.Request, resp *bulkResp, allOK bool) {
+	if isV1(r) {
+		s.reply(w, r, http.StatusOK, resp)
+		return
+	}
+	s.writeJSON(w, r, struct {
+		Success bool `json:"success"`
+		*bulkResp
+	}{allOK, resp})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/uluyol/bernie"
+)
+
+func TestSelectorValidate(t *testing.T) {
+	tests := []struct {
+		sel      selector
+		forTasks bool
+		ok       bool
+	}{
+		{sel: selector{}, forTasks: true},
+		{sel: selector{Name: "a*"}, forTasks: true, ok: true},
+		{sel: selector{State: "failed"}, forTasks: false, ok: true},
+		{sel: selector{Name: "[a"}, forTasks: true},
+		{sel: selector{Label: "k=v"}, forTasks: true, ok: true},
+		{sel: selector{Label: "k=v"}, forTasks: false},
+		{sel: selector{Worker: "a-000"}, forTasks: false},
+	}
+	for _, test := range tests {
+		err := test.sel.validate(test.forTasks)
+		if (err == nil) != test.ok {
+			t.Errorf("%+v.validate(%t) = %v, want ok = %t", test.sel, test.forTasks, err, test.ok)
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	tests := []struct {
+		sel   selector
+		name  string
+		state string
+		want  bool
+	}{
+		{sel: selector{Name: "sweep-*"}, name: "sweep-12", want: true},
+		{sel: selector{Name: "sweep-*"}, name: "other-12", want: false},
+		{sel: selector{Name: "sweep-?"}, name: "sweep-12", want: false},
+		{sel: selector{Name: "sweep-[0-9]*"}, name: "sweep-12", want: true},
+		{sel: selector{Name: `a\*b`}, name: "a*b", want: true},
+		{sel: selector{Name: `a\*b`}, name: "axb", want: false},
+		{sel: selector{Name: "*"}, name: "a/b", want: false},
+		{sel: selector{State: "failed"}, state: "failed", want: true},
+		{sel: selector{State: "queued,failed"}, state: "failed", want: true},
+		{sel: selector{State: "queued,running"}, state: "failed", want: false},
+		{sel: selector{State: "Failed"}, state: "failed", want: true},
+		{sel: selector{Name: "a*", State: "failed"}, name: "ab", state: "queued", want: false},
+		{sel: selector{Name: "a*", State: "failed"}, name: "ab", state: "failed", want: true},
+	}
+	for _, test := range tests {
+		if got := test.sel.matches(test.name, test.state); got != test.want {
+			t.Errorf("%+v.matches(%q, %q) = %t, want %t", test.sel, test.name, test.state, got, test.want)
+		}
+	}
+}
+
+func TestSelectorMatchesTask(t *testing.T) {
+	task := &bernie.Task{
+		Name:   "sweep-1",
+		Labels: map[string]string{"sweep": "lr", "seed": ""},
+	}
+	tests := []struct {
+		sel  selector
+		want bool
+	}{
+		{sel: selector{Label: "sweep=lr"}, want: true},
+		{sel: selector{Label: "sweep=bs"}, want: false},
+		{sel: selector{Label: "sweep"}, want: true},
+		{sel: selector{Label: "seed="}, want: true},
+		{sel: selector{Label: "seed"}, want: true},
+		{sel: selector{Label: "other"}, want: false},
+		{sel: selector{Name: "sweep-*", Label: "sweep=lr", State: "queued"}, want: true},
+		{sel: selector{Name: "sweep-*", Label: "sweep=lr", State: "running"}, want: false},
+		{sel: selector{Worker: "a-000"}, want: false},
+	}
+	for _, test := range tests {
+		if got := test.sel.matchesTask(task, 3); got != test.want {
+			t.Errorf("%+v.matchesTask = %t, want %t", test.sel, got, test.want)
+		}
+	}
+}
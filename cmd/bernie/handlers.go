@@ -1,10 +1,16 @@
 package main
 
 import (
-	"bytes"
+	"archive/tar"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -14,76 +20,308 @@ import (
 type handler struct {
 	log    logrus.FieldLogger
 	bernie bernieServer
+
+	// openapi describes the routes, as generated when they are registered.
+	openapi interface{}
 }
 
 func (s *handler) rootHandler(w http.ResponseWriter, r *http.Request) {
 	rootTempl.Execute(w, struct {
 		Groups []Group
+		Shared *bernie.SharedPool
 	}{
 		s.bernie.Groups(),
+		s.bernie.shared,
 	})
 }
 
 type groupsAddReq struct {
 	Name string       `json:"name"`
 	Init *bernie.Task `json:"init"`
+	groupOpts
 }
 
 // Possible paths:
 // /groups/add
+// /v1/groups
 func (s *handler) groupsAddHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("content-type", "application/json")
-	dec := json.NewDecoder(r.Body)
 	var reqData groupsAddReq
-	if err := dec.Decode(&reqData); err != nil {
-		s.log.WithFields(logrus.Fields{
-			"err":  err,
-			"path": r.URL.Path,
-		}).Error("unable to decode")
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintln(w, `{"success": false, "reason": "error decoding request"}`)
+	if !s.decodeBodyInto(w, r, &reqData) {
 		return
 	}
 	if reqData.Init == nil {
 		s.log.WithField("path", r.URL.Path).Info("missing init task")
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintln(w, `{"success": false, "reason": "need init task"}`)
+		s.fail(w, r, codeInvalid, "need init task")
+		return
+	}
+	if err := reqData.groupOpts.validate(); err != nil {
+		s.fail(w, r, codeInvalid, err.Error())
+		return
+	}
+	if !s.bernie.addGroup(reqData.Name, reqData.Init, reqData.groupOpts) {
+		s.fail(w, r, codeExists, "cannot create existing group")
+		return
+	}
+	s.reply(w, r, http.StatusCreated, nil)
+}
+
+// Possible paths:
+// /groups/{group}
+func (s *handler) groupsDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	switch err := s.bernie.rmGroup(group); err {
+	case nil:
+		s.reply(w, r, http.StatusNoContent, nil)
+	case errGroupNotExist:
+		s.fail(w, r, codeNotFound, err.Error())
+	default:
+		s.log.WithFields(logrus.Fields{
+			"err":   err,
+			"group": group,
+		}).Error("unable to remove group")
+		s.fail(w, r, codeInternal, err.Error())
+	}
+}
+
+// groupsPatchReq changes the limits of a group.
+// Fields that are 0 are left unchanged.
+type groupsPatchReq struct {
+	MaxTries int `json:"maxtries"`
+	MaxFails int `json:"maxfails"`
+}
+
+// Possible paths:
+// /groups/{group}?maxtries=N&maxfails=N
+// /v1/groups/{group}
+func (s *handler) groupsPatchHandler(w http.ResponseWriter, r *http.Request) {
+	var reqData groupsPatchReq
+	if isV1(r) {
+		if !s.decodeStrictBodyInto(w, r, &reqData) {
+			return
+		}
+		if reqData.MaxTries < 0 || reqData.MaxFails < 0 {
+			s.fail(w, r, codeInvalid, "maxtries and maxfails must be positive")
+			return
+		}
+	} else {
+		q := r.URL.Query()
+		for _, f := range []struct {
+			key string
+			dst *int
+		}{{"maxtries", &reqData.MaxTries}, {"maxfails", &reqData.MaxFails}} {
+			v := q.Get(f.key)
+			if v == "" {
+				continue
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				s.fail(w, r, codeInvalid, f.key+" must be a positive integer")
+				return
+			}
+			*f.dst = n
+		}
+	}
+	if reqData.MaxTries == 0 && reqData.MaxFails == 0 {
+		s.fail(w, r, codeInvalid, "unknown or unprovided field")
+		return
+	}
+	p := s.bernie.pool(mux.Vars(r)["group"])
+	if p == nil {
+		s.fail(w, r, codeNotFound, errGroupNotExist.Error())
+		return
+	}
+	if reqData.MaxTries > 0 {
+		p.SetAllowableTaskTries(reqData.MaxTries)
+	}
+	if reqData.MaxFails > 0 {
+		p.SetAllowableWorkerFailures(reqData.MaxFails)
+	}
+	s.reply(w, r, http.StatusNoContent, nil)
+}
+
+type secretsReq struct {
+	Secrets map[string]string `json:"secrets"`
+}
+
+// Possible paths:
+// /groups/{group}/secrets
+func (s *handler) groupsSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	var reqData secretsReq
+	if !s.decodeBodyInto(w, r, &reqData) {
+		return
+	}
+	if err := validateSecrets(reqData.Secrets); err != nil {
+		s.fail(w, r, codeInvalid, err.Error())
+		return
+	}
+	p := s.bernie.pool(group)
+	if p == nil {
+		s.fail(w, r, codeNotFound, errGroupNotExist.Error())
+		return
+	}
+	p.SetSecrets(reqData.Secrets)
+	s.reply(w, r, http.StatusNoContent, nil)
+}
+
+// Possible paths:
+// /groups/{group}/secrets/{key}
+func (s *handler) groupsSecretDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	p := s.bernie.pool(vars["group"])
+	if p == nil {
+		s.fail(w, r, codeNotFound, errGroupNotExist.Error())
+		return
+	}
+	p.SetSecrets(map[string]string{vars["key"]: ""})
+	s.reply(w, r, http.StatusNoContent, nil)
+}
+
+type groupsInitReq struct {
+	Init      *bernie.Task `json:"init"`
+	MaxReinit int          `json:"maxreinit"`
+}
+
+type groupsInitResp struct {
+	Version int `json:"version"`
+}
+
+// Possible paths:
+// /groups/{group}/init
+func (s *handler) groupsInitHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	var reqData groupsInitReq
+	if !s.decodeBodyInto(w, r, &reqData) {
+		return
+	}
+	if reqData.Init == nil {
+		s.fail(w, r, codeInvalid, "need init task")
+		return
+	}
+	p := s.bernie.pool(group)
+	if p == nil {
+		s.fail(w, r, codeNotFound, errGroupNotExist.Error())
+		return
+	}
+	v := p.SetInitTask(reqData.Init, reqData.MaxReinit)
+	s.log.WithFields(logrus.Fields{
+		"group":   group,
+		"version": v,
+	}).Info("updated init task")
+	s.reply(w, r, http.StatusOK, groupsInitResp{v})
+}
+
+type groupsPauseReq struct {
+	By     string `json:"by"`
+	Reason string `json:"reason"`
+}
+
+type pauseView struct {
+	Paused bool `json:"paused"`
+	*bernie.PauseInfo
+}
+
+// Possible paths:
+// /groups/{group}/pause
+func (s *handler) groupsPauseHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	var reqData groupsPauseReq
+	if !s.decodeOptionalBodyInto(w, r, &reqData) {
+		return
+	}
+	if reqData.By == "" {
+		reqData.By = r.RemoteAddr
+	}
+	p := s.bernie.pool(group)
+	if p == nil {
+		s.fail(w, r, codeNotFound, errGroupNotExist.Error())
+		return
+	}
+	p.Pause(reqData.By, reqData.Reason)
+	s.log.WithFields(logrus.Fields{
+		"group":  group,
+		"by":     reqData.By,
+		"reason": reqData.Reason,
+	}).Info("paused group")
+	s.reply(w, r, http.StatusNoContent, nil)
+}
+
+// Possible paths:
+// /groups/{group}/resume
+func (s *handler) groupsResumeHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	p := s.bernie.pool(group)
+	if p == nil {
+		s.fail(w, r, codeNotFound, errGroupNotExist.Error())
 		return
 	}
-	if !s.bernie.addGroup(reqData.Name, reqData.Init) {
-		w.WriteHeader(http.StatusConflict)
-		fmt.Fprintln(w, `{"success": false, "reason": "cannot create existing group"}`)
+	p.Resume()
+	s.log.WithField("group", group).Info("resumed group")
+	s.reply(w, r, http.StatusNoContent, nil)
+}
+
+// Possible paths:
+// /groups/{group}/pause
+func (s *handler) groupsPausedHandler(w http.ResponseWriter, r *http.Request) {
+	p := s.bernie.pool(mux.Vars(r)["group"])
+	if p == nil {
+		s.fail(w, r, codeNotFound, errGroupNotExist.Error())
 		return
 	}
-	fmt.Fprintln(w, `{"success": true}`)
+	info := p.Paused()
+	s.writeJSON(w, r, pauseView{info != nil, info})
 }
 
 type tasksAddReq struct {
 	Tasks []*bernie.Task `json:"tasks"`
 }
 
+type tasksAddResp struct {
+	Added []string `json:"added"`
+	// Exist are the tasks that were not added because the group has tasks of their names.
+	Exist []string `json:"exist"`
+}
+
+type legacyTasksAddResp struct {
+	Success bool   `json:"success"`
+	Reason  string `json:"reason"`
+	tasksAddResp
+}
+
+// Possible paths:
+// /tasks/{group}/add
+// /v1/tasks/{group}
 func (s *handler) tasksAddHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("content-type", "application/json")
 	vars := mux.Vars(r)
 	group := vars["group"]
 	var reqData tasksAddReq
 	if !s.decodeBodyInto(w, r, &reqData) {
 		return
 	}
+	for _, t := range reqData.Tasks {
+		if t.Exit == nil {
+			continue
+		}
+		if err := t.Exit.Validate(); err != nil {
+			s.failDetails(w, r, codeInvalid, "bad exit policy for "+t.Name+": "+err.Error(),
+				map[string]string{"task": t.Name})
+			return
+		}
+	}
 
 	succ, fail, err := s.bernie.addTasks(group, reqData.Tasks)
-	resp := struct {
-		Success bool     `json:"success"`
-		Reason  string   `json:"reason"`
-		Added   []string `json:"added"`
-		Exist   []string `json:"exist"`
-	}{
-		Success: len(fail) == 0 && err == nil,
-		Added:   make([]string, len(succ)),
-		Exist:   make([]string, len(fail)),
+	if err != nil && !isV1(r) {
+		// Legacy clients are told that the group does not exist in a successful reply.
+		s.writeJSON(w, r, legacyTasksAddResp{false, err.Error(), tasksAddResp{[]string{}, []string{}}})
+		return
+	}
+	if err != nil {
+		s.fail(w, r, codeNotFound, err.Error())
+		return
 	}
-	if err == errGroupNotExist {
-		resp.Reason = err.Error()
+	resp := tasksAddResp{
+		Added: make([]string, len(succ)),
+		Exist: make([]string, len(fail)),
 	}
 	for i, t := range succ {
 		resp.Added[i] = t.Name
@@ -91,31 +329,33 @@ func (s *handler) tasksAddHandler(w http.ResponseWriter, r *http.Request) {
 	for i, t := range fail {
 		resp.Exist[i] = t.Name
 	}
-
-	b, err := json.Marshal(resp)
-	if err != nil {
-		s.log.WithField("err", err).Error("unable to marshal response")
-		w.WriteHeader(http.StatusInternalServerError)
-		b = []byte(`{"success": false, "reason": "unable to marshal response"}`)
+	switch {
+	case !isV1(r):
+		// Legacy clients learn that some tasks existed from success.
+		s.writeJSON(w, r, legacyTasksAddResp{len(fail) == 0, "", resp})
+	case len(fail) > 0:
+		s.reply(w, r, http.StatusOK, &resp)
+	default:
+		s.reply(w, r, http.StatusCreated, &resp)
 	}
-	fmt.Fprintln(w, string(b))
 }
 
 func (s *handler) tasksDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("content-type", "application/json")
 	vars := mux.Vars(r)
 	group := vars["group"]
 	task := vars["task"]
 	err := s.bernie.rmTask(group, task)
+	if err == errTaskNotExist && !isV1(r) {
+		// Legacy clients could remove tasks that do not exist.
+		err = nil
+	}
 	switch err {
 	case nil:
-		fmt.Fprintln(w, `{"success": true}`)
-	case errGroupNotExist:
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintf(w, "{\"success\": false, \"reason\": %q}\n", err.Error())
+		s.reply(w, r, http.StatusNoContent, nil)
+	case errGroupNotExist, errTaskNotExist:
+		s.fail(w, r, codeNotFound, err.Error())
 	default:
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintln(w, `{"success": false}`)
+		s.failInternal(w, r, err.Error())
 	}
 }
 
@@ -128,6 +368,28 @@ func getTask(ts []*bernie.Task, name string) (*bernie.Task, bool) {
 	return nil, false
 }
 
+// workers returns the workers of the group named in the request,
+// or the shared workers for routes without a group.
+func (s *handler) workers(r *http.Request) []*bernie.Worker {
+	if group, ok := mux.Vars(r)["group"]; ok {
+		return s.bernie.Workers(group)
+	}
+	return s.bernie.SharedWorkers()
+}
+
+// resetFailures resets the failures of wk, a worker of the group in r's path
+// or a shared worker if there is no group.
+func (s *handler) resetFailures(r *http.Request, wk *bernie.Worker) {
+	group, ok := mux.Vars(r)["group"]
+	if !ok {
+		s.bernie.shared.ResetFailures(wk)
+		return
+	}
+	if p := s.bernie.pool(group); p != nil {
+		p.ResetFailures(wk)
+	}
+}
+
 func getWorker(ws []*bernie.Worker, name string) (*bernie.Worker, bool) {
 	for _, w := range ws {
 		if w.Name() == name {
@@ -137,78 +399,190 @@ func getWorker(ws []*bernie.Worker, name string) (*bernie.Worker, bool) {
 	return nil, false
 }
 
+// tasksPatchReq changes a task. Tries can only be reset to 0.
+type tasksPatchReq struct {
+	Tries *int `json:"tries"`
+}
+
+// Possible paths:
+// /tasks/{group}/{task}?status-tries=0
+// /v1/tasks/{group}/{task}
 func (s *handler) tasksPatchHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("content-type", "application/json")
 	vars := mux.Vars(r)
 	group := vars["group"]
 	task := vars["task"]
-	if r.URL.RawQuery == "status-tries=0" {
-		if t, ok := getTask(s.bernie.Tasks(group), task); ok {
-			t.ResetTries()
-			fmt.Fprintln(w, `{"success": true}`)
+	if isV1(r) {
+		var reqData tasksPatchReq
+		if !s.decodeStrictBodyInto(w, r, &reqData) {
+			return
+		}
+		if reqData.Tries == nil {
+			s.fail(w, r, codeInvalid, "unknown or unprovided field")
 			return
 		}
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintln(w, `{"success": false, "reason": "unknown group or task"}`)
+		if *reqData.Tries != 0 {
+			s.fail(w, r, codeInvalid, "tries can only be reset to 0")
+			return
+		}
+	} else if r.URL.RawQuery != "status-tries=0" {
+		s.fail(w, r, codeInvalid, "unknown or unprovided field")
 		return
 	}
-	w.WriteHeader(http.StatusBadRequest)
-	fmt.Fprintln(w, `{"success": false, "reason": "unknown or unprovided field"}`)
+	t, ok := getTask(s.bernie.Tasks(group), task)
+	if !ok {
+		s.fail(w, r, codeNotFound, "unknown group or task")
+		return
+	}
+	t.ResetTries()
+	s.reply(w, r, http.StatusNoContent, nil)
+}
+
+type usageView struct {
+	UserCPU    float64 `json:"usercpu"`
+	SystemCPU  float64 `json:"systemcpu"`
+	MaxRSS     int64   `json:"maxrss"`
+	ReadBytes  int64   `json:"readbytes"`
+	WriteBytes int64   `json:"writebytes"`
+	Source     string  `json:"source"`
+}
+
+type attemptView struct {
+	Worker     string    `json:"worker"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Err        string    `json:"err,omitempty"`
+	Usage      usageView `json:"usage"`
+	CachedFrom string    `json:"cachedfrom,omitempty"`
+	// Suspended is the number of seconds the attempt was suspended.
+	Suspended float64 `json:"suspended,omitempty"`
+	// ExitCode is the exit code of the command, or -1 if it did not exit.
+	// It is kept even if the exit counts as success.
+	ExitCode int `json:"exitcode"`
+	// Signal is the name of the signal that killed the command, if any.
+	Signal string `json:"signal,omitempty"`
+}
+
+func newAttemptView(a bernie.Attempt) attemptView {
+	v := attemptView{
+		Worker:     a.Worker,
+		Start:      a.Start,
+		End:        a.End,
+		CachedFrom: a.CachedFrom,
+		Suspended:  a.Suspended.Seconds(),
+		ExitCode:   a.ExitCode,
+		Signal:     a.Signal,
+		Usage: usageView{
+			UserCPU:    a.Usage.UserCPU.Seconds(),
+			SystemCPU:  a.Usage.SystemCPU.Seconds(),
+			MaxRSS:     a.Usage.MaxRSS,
+			ReadBytes:  a.Usage.ReadBytes,
+			WriteBytes: a.Usage.WriteBytes,
+			Source:     a.Usage.Source,
+		},
+	}
+	if a.Err != nil {
+		v.Err = a.Err.Error()
+	}
+	return v
+}
+
+// taskManifestView is a task and the settings of its group that affect it.
+type taskManifestView struct {
+	taskView
+	Group struct {
+		Env     []string  `json:"env"`
+		Secrets []string  `json:"secrets"`
+		Paused  pauseView `json:"paused"`
+	} `json:"group"`
 }
 
 func (s *handler) tasksManifestHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("content-type", "text/plain")
 	vars := mux.Vars(r)
 	group := vars["group"]
 	task := vars["task"]
 	if t, ok := getTask(s.bernie.Tasks(group), task); ok {
-		manifest := struct {
-			Name   string   `json:"name"`
-			Cmd    []string `json:"cmd"`
-			Env    []string `json:"env"`
-			WD     string   `json:"wd"`
-			Status struct {
-				Tmux struct {
-					Session string `json:"session"`
-				} `json:"tmux"`
-			} `json:"status"`
-		}{
-			Name: t.Name,
-			Cmd:  t.Cmd,
-			Env:  t.Env,
-			WD:   t.WD,
+		p := s.bernie.pool(group)
+		manifest := taskManifestView{taskView: newTaskView(p, t)}
+		secretKeys := p.SecretKeys()
+		manifest.Group.Env = bernie.RedactEnv(p.Env(), secretKeys)
+		manifest.Group.Secrets = secretKeys
+		if info := p.Paused(); info != nil {
+			manifest.Group.Paused = pauseView{true, info}
 		}
-		manifest.Status.Tmux.Session = t.Status().Tmux.Session
-		b, err := json.Marshal(&manifest)
-		if err != nil {
-			s.log.WithFields(logrus.Fields{
-				"err":  err,
-				"path": r.URL.Path,
-			}).Error("unable to encode task manifest")
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintln(w, "unable to encode task manifest")
-			return
-		}
-		var buf bytes.Buffer
-		if err := json.Indent(&buf, b, "", "  "); err != nil {
-			s.log.WithFields(logrus.Fields{
-				"err":  err,
-				"path": r.URL.Path,
-			}).Error("unable to indent json")
-			buf.Reset()
-			buf.Write(b)
-		}
-		buf.WriteByte('\n')
-		w.Header().Add("content-type", "application/json")
-		buf.WriteTo(w)
+		s.writeJSON(w, r, &manifest)
 		return
 	}
-	w.WriteHeader(http.StatusNotFound)
-	fmt.Fprintln(w, "unknown group or task")
+	if !isV1(r) {
+		// Legacy clients were told in plain text.
+		w.Header().Set("content-type", "text/plain")
+	}
+	s.fail(w, r, codeNotFound, "unknown group or task")
+}
+
+// Possible paths:
+// /tasks/{group}/{task}/suspend
+// /tasks/{group}/{task}/resume
+func (s *handler) tasksSuspendHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	t, ok := getTask(s.bernie.Tasks(vars["group"]), vars["task"])
+	if !ok {
+		s.fail(w, r, codeNotFound, "unknown group or task")
+		return
+	}
+	var err error
+	if path.Base(r.URL.Path) == "resume" {
+		err = t.Resume()
+	} else {
+		err = t.Suspend()
+	}
+	if err != nil {
+		s.fail(w, r, codeConflict, err.Error())
+		return
+	}
+	s.reply(w, r, http.StatusNoContent, nil)
+}
+
+// Possible paths:
+// /tasks/{group}/{task}/rerun
+func (s *handler) tasksRerunHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	switch err := s.bernie.rerunTask(vars["group"], vars["task"]); err {
+	case nil:
+		s.reply(w, r, http.StatusNoContent, nil)
+	case errGroupNotExist, errTaskNotExist:
+		s.fail(w, r, codeNotFound, err.Error())
+	default:
+		s.fail(w, r, codeConflict, err.Error())
+	}
+}
+
+type tasksCloneResp struct {
+	Name string `json:"name"`
+}
+
+// Possible paths:
+// /tasks/{group}/{task}/clone
+func (s *handler) tasksCloneHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var reqData cloneOpts
+	if !s.decodeBodyInto(w, r, &reqData) {
+		return
+	}
+	c, err := s.bernie.cloneTask(vars["group"], vars["task"], reqData)
+	switch err {
+	case nil:
+		s.reply(w, r, http.StatusCreated, tasksCloneResp{c.Name})
+	case errGroupNotExist, errTaskNotExist:
+		s.fail(w, r, codeNotFound, err.Error())
+	case errTaskExists:
+		s.fail(w, r, codeExists, err.Error())
+	default:
+		s.fail(w, r, codeConflict, err.Error())
+	}
 }
 
 func (s *handler) tasksOutHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("content-type", "text/plain")
+	w.Header().Set("content-type", "text/plain")
 	vars := mux.Vars(r)
 	group := vars["group"]
 	task := vars["task"]
@@ -222,12 +596,189 @@ func (s *handler) tasksOutHandler(w http.ResponseWriter, r *http.Request) {
 			"err":  err,
 			"path": r.URL.Path,
 		}).Error("unable to get output")
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintln(w, "unable to get output")
+		s.fail(w, r, codeInternal, "unable to get output")
+		return
+	}
+	s.fail(w, r, codeNotFound, "unknown group or task")
+}
+
+type artifact struct {
+	Attempt int    `json:"attempt"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+}
+
+// artifacts lists the artifacts of t.
+// If attempt is positive, only that attempt's artifacts are listed.
+func artifacts(p *bernie.WorkerPool, t *bernie.Task, attempt int) ([]artifact, error) {
+	var as []artifact
+	for n := 1; n <= len(t.Status().Attempts); n++ {
+		if attempt > 0 && n != attempt {
+			continue
+		}
+		dir := p.AttemptArtifactDir(t, n)
+		if dir == "" {
+			continue
+		}
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			as = append(as, artifact{
+				Attempt: n,
+				Path:    filepath.ToSlash(rel),
+				Size:    info.Size(),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return as, nil
+}
+
+type artifactsResp struct {
+	Artifacts []artifact `json:"artifacts"`
+}
+
+func (s *handler) tasksArtifactsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	group := vars["group"]
+	task := vars["task"]
+	t, ok := getTask(s.bernie.Tasks(group), task)
+	if !ok {
+		s.fail(w, r, codeNotFound, "unknown group or task")
+		return
+	}
+	as, err := artifacts(s.bernie.pool(group), t, 0)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"err":  err,
+			"path": r.URL.Path,
+		}).Error("unable to list artifacts")
+		s.fail(w, r, codeInternal, "unable to list artifacts")
 		return
 	}
-	w.WriteHeader(http.StatusNotFound)
-	fmt.Fprintln(w, "unknown group or task")
+	if as == nil {
+		as = []artifact{}
+	}
+	s.reply(w, r, http.StatusOK, artifactsResp{as})
+}
+
+func (s *handler) tasksArtifactHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	group := vars["group"]
+	task := vars["task"]
+	t, ok := getTask(s.bernie.Tasks(group), task)
+	attempt, err := strconv.Atoi(vars["attempt"])
+	if !ok || err != nil {
+		w.Header().Set("content-type", "text/plain")
+		s.fail(w, r, codeNotFound, "unknown group, task, or attempt")
+		return
+	}
+	dir := s.bernie.pool(group).AttemptArtifactDir(t, attempt)
+	rel := path.Clean("/" + vars["path"])
+	if dir == "" {
+		w.Header().Set("content-type", "text/plain")
+		s.fail(w, r, codeNotFound, "artifacts are not collected")
+		return
+	}
+	name := filepath.Join(dir, filepath.FromSlash(rel))
+	f, err := os.Open(name)
+	if err != nil {
+		w.Header().Set("content-type", "text/plain")
+		s.fail(w, r, codeNotFound, "unknown artifact")
+		return
+	}
+	defer f.Close()
+	// Only files are artifacts, so directories are not listed.
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		w.Header().Set("content-type", "text/plain")
+		s.fail(w, r, codeNotFound, "unknown artifact")
+		return
+	}
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+}
+
+func (s *handler) tasksArtifactsTarHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	group := vars["group"]
+	task := vars["task"]
+	t, ok := getTask(s.bernie.Tasks(group), task)
+	w.Header().Set("content-type", "text/plain")
+	if !ok {
+		s.fail(w, r, codeNotFound, "unknown group or task")
+		return
+	}
+	attempt := 0
+	if v := r.URL.Query().Get("attempt"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			s.fail(w, r, codeInvalid, "bad attempt")
+			return
+		}
+		attempt = n
+	}
+	p := s.bernie.pool(group)
+	as, err := artifacts(p, t, attempt)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"err":  err,
+			"path": r.URL.Path,
+		}).Error("unable to list artifacts")
+		s.fail(w, r, codeInternal, "unable to list artifacts")
+		return
+	}
+
+	w.Header().Set("content-type", "application/x-tar")
+	tw := tar.NewWriter(w)
+	for _, a := range as {
+		name := filepath.Join(p.AttemptArtifactDir(t, a.Attempt), filepath.FromSlash(a.Path))
+		if err := writeTarFile(tw, path.Join(strconv.Itoa(a.Attempt), a.Path), name); err != nil {
+			s.log.WithFields(logrus.Fields{
+				"err":  err,
+				"path": r.URL.Path,
+			}).Error("unable to write artifact to tar")
+			return
+		}
+	}
+	if err := tw.Close(); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"err":  err,
+			"path": r.URL.Path,
+		}).Error("unable to finish tar")
+	}
+}
+
+func writeTarFile(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
 }
 
 type workersAddReq struct {
@@ -236,10 +787,14 @@ type workersAddReq struct {
 	} `json:"workers"`
 }
 
+// Possible paths:
+// /workers/{group}/add
+// /shared/workers/add
+// /v1/workers/{group}
+// /v1/shared/workers
 func (s *handler) workersAddHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("content-type", "application/json")
 	vars := mux.Vars(r)
-	group := vars["group"]
+	group, grouped := vars["group"]
 	var reqData workersAddReq
 	if !s.decodeBodyInto(w, r, &reqData) {
 		return
@@ -249,72 +804,100 @@ func (s *handler) workersAddHandler(w http.ResponseWriter, r *http.Request) {
 	for i := range reqData.Workers {
 		manifests[i] = reqData.Workers[i].Manifest
 	}
+	if !grouped {
+		s.bernie.addSharedWorkers(manifests)
+		s.reply(w, r, http.StatusCreated, nil)
+		return
+	}
 	err := s.bernie.addWorkers(group, manifests)
 	if err == nil {
-		fmt.Fprintln(w, `{"success": true}`)
+		s.reply(w, r, http.StatusCreated, nil)
+		return
+	}
+	switch err := err.(type) {
+	case *badManifestError:
+		s.failDetails(w, r, codeInvalid, err.Error(), map[string]int{"worker": err.index})
 		return
 	}
 	if err != errGroupNotExist {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintln(w, `{"success": false}`)
+		s.failInternal(w, r, err.Error())
 	} else {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintf(w, "{\"success\": false, \"reason\": %q}\n", err.Error())
+		s.fail(w, r, codeNotFound, err.Error())
 	}
 }
 
 func (s *handler) workersDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("content-type", "application/json")
 	vars := mux.Vars(r)
-	group := vars["group"]
+	group, grouped := vars["group"]
 	worker := vars["worker"]
+	// Legacy clients could remove workers that do not exist from a group.
+	if _, ok := getWorker(s.workers(r), worker); !ok && (isV1(r) || !grouped) {
+		s.fail(w, r, codeNotFound, "unknown group or worker")
+		return
+	}
+	if !grouped {
+		s.bernie.rmSharedWorker(worker)
+		s.reply(w, r, http.StatusNoContent, nil)
+		return
+	}
 	if !s.bernie.rmWorker(group, worker) {
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintln(w, `{"success": false, "reason": "unknown group"}`)
+		s.fail(w, r, codeNotFound, "unknown group")
 		return
 	}
-	fmt.Fprintln(w, `{"success": true}`)
+	s.reply(w, r, http.StatusNoContent, nil)
+}
+
+// workersPatchReq changes a worker. Failed tasks can only be reset to 0.
+type workersPatchReq struct {
+	FailedTasks *int `json:"failedtasks"`
 }
 
+// Possible paths:
+// /workers/{group}/{worker}?status-failedtasks=0
+// /shared/workers/{worker}?status-failedtasks=0
+// /v1/workers/{group}/{worker}
+// /v1/shared/workers/{worker}
 func (s *handler) workersPatchHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("content-type", "application/json")
-	vars := mux.Vars(r)
-	group := vars["group"]
-	worker := vars["worker"]
-	if r.URL.RawQuery == "status-failedtasks=0" {
-		if worker, ok := getWorker(s.bernie.Workers(group), worker); ok {
-			worker.ResetFailures()
-			fmt.Fprintln(w, `{"success": true}`)
+	if isV1(r) {
+		var reqData workersPatchReq
+		if !s.decodeStrictBodyInto(w, r, &reqData) {
+			return
+		}
+		if reqData.FailedTasks == nil {
+			s.fail(w, r, codeInvalid, "unknown or unprovided field")
 			return
 		}
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintln(w, `{"success": false, "reason": "unknown group or worker"}`)
+		if *reqData.FailedTasks != 0 {
+			s.fail(w, r, codeInvalid, "failedtasks can only be reset to 0")
+			return
+		}
+	} else if r.URL.RawQuery != "status-failedtasks=0" {
+		s.fail(w, r, codeInvalid, "unknown or unprovided field")
 		return
 	}
-	w.WriteHeader(http.StatusBadRequest)
-	fmt.Fprintln(w, `{"success": false, "reason": "unknown or unprovided field"}`)
+	worker, ok := getWorker(s.workers(r), mux.Vars(r)["worker"])
+	if !ok {
+		s.fail(w, r, codeNotFound, "unknown group or worker")
+		return
+	}
+	s.resetFailures(r, worker)
+	s.reply(w, r, http.StatusNoContent, nil)
 }
 
 func (s *handler) workersManifestHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("content-type", "text/plain")
-	vars := mux.Vars(r)
-	group := vars["group"]
-	worker := vars["worker"]
-	if worker, ok := getWorker(s.bernie.Workers(group), worker); ok {
+	w.Header().Set("content-type", "text/plain")
+	worker := mux.Vars(r)["worker"]
+	if worker, ok := getWorker(s.workers(r), worker); ok {
 		fmt.Fprintln(w, worker.Manifest())
 		return
 	}
-	w.WriteHeader(http.StatusNotFound)
-	fmt.Fprintln(w, "unknown group or worker")
-	return
+	s.fail(w, r, codeNotFound, "unknown group or worker")
 }
 
 func (s *handler) workersInitOutHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("content-type", "text/plain")
-	vars := mux.Vars(r)
-	group := vars["group"]
-	worker := vars["worker"]
-	if worker, ok := getWorker(s.bernie.Workers(group), worker); ok {
+	w.Header().Set("content-type", "text/plain")
+	worker := mux.Vars(r)["worker"]
+	if worker, ok := getWorker(s.workers(r), worker); ok {
 		t := worker.Status().InitTask
 		if t == nil {
 			fmt.Fprintln(w, "init task not yet created")
@@ -329,23 +912,87 @@ func (s *handler) workersInitOutHandler(w http.ResponseWriter, r *http.Request)
 			"err":  err,
 			"path": r.URL.Path,
 		}).Error("unable to get output")
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintln(w, "unable to get output")
+		s.fail(w, r, codeInternal, "unable to get output")
+		return
+	}
+	s.fail(w, r, codeNotFound, "unknown group or worker")
+}
+
+// Possible paths:
+// /tasks/{group}/{task}/session
+// /workers/{group}/{worker}/session
+// /shared/workers/{worker}/session
+//
+// For workers, the session is that of the worker's init task.
+func (s *handler) sessionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var t *bernie.Task
+	if name, ok := vars["task"]; ok {
+		t, ok = getTask(s.bernie.Tasks(vars["group"]), name)
+		if !ok {
+			s.fail(w, r, codeNotFound, "unknown group or task")
+			return
+		}
+	} else {
+		worker, ok := getWorker(s.workers(r), vars["worker"])
+		if !ok {
+			s.fail(w, r, codeNotFound, "unknown group or worker")
+			return
+		}
+		if t = worker.Status().InitTask; t == nil {
+			s.fail(w, r, codeNotFound, "init task not yet created")
+			return
+		}
+	}
+	st := t.Status()
+	if st.Tmux.Session == "" {
+		s.fail(w, r, codeNotFound, "task has not started")
 		return
 	}
-	w.WriteHeader(http.StatusNotFound)
-	fmt.Fprintln(w, "unknown group or worker")
-	return
+	s.reply(w, r, http.StatusOK, sessionView{st.Tmux.Session, st.SessionAlive()})
+}
+
+type sessionView struct {
+	Session string `json:"session"`
+	Alive   bool   `json:"alive"`
 }
 
 func (s *handler) decodeBodyInto(w http.ResponseWriter, r *http.Request, out interface{}) (ok bool) {
-	if err := json.NewDecoder(r.Body).Decode(out); err != nil {
+	return s.decode(w, r, json.NewDecoder(r.Body), out)
+}
+
+// decodeStrictBodyInto is like decodeBodyInto but rejects unknown fields,
+// so that misspelled changes are not ignored.
+func (s *handler) decodeStrictBodyInto(w http.ResponseWriter, r *http.Request, out interface{}) (ok bool) {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return s.decode(w, r, dec, out)
+}
+
+// decodeOptionalBodyInto is like decodeBodyInto but leaves out as is if the body is empty.
+func (s *handler) decodeOptionalBodyInto(w http.ResponseWriter, r *http.Request, out interface{}) (ok bool) {
+	err := json.NewDecoder(r.Body).Decode(out)
+	if err == io.EOF {
+		return true
+	}
+	return s.decodeErr(w, r, err)
+}
+
+func (s *handler) decode(w http.ResponseWriter, r *http.Request, dec *json.Decoder, out interface{}) (ok bool) {
+	return s.decodeErr(w, r, dec.Decode(out))
+}
+
+func (s *handler) decodeErr(w http.ResponseWriter, r *http.Request, err error) (ok bool) {
+	if err != nil {
 		s.log.WithFields(logrus.Fields{
 			"err":  err,
 			"path": r.URL.Path,
 		}).Error("unable to decode")
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintln(w, `{"success": false, "reason": "error decoding request"}`)
+		msg := "error decoding request"
+		if isV1(r) {
+			msg += ": " + err.Error()
+		}
+		s.fail(w, r, codeInvalid, msg)
 		return false
 	}
 	return true
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTasksArtifactHandler(t *testing.T) {
+	log := discardLogger()
+	s := &handler{log: log, bernie: bernieServer{log: log}}
+	s.bernie.init()
+	r := mux.NewRouter()
+	s.register(r)
+
+	dir, err := ioutil.TempDir("", "bernie-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, req := range []struct{ path, body string }{
+		{"/v1/groups", `{"name": "g", "init": {"cmd": ["true"]}}`},
+		{"/v1/tasks/g", `{"tasks": [{"name": "a", "cmd": ["true"]}]}`},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("POST", req.path, strings.NewReader(req.body)))
+		if rec.Code != 201 {
+			t.Fatalf("POST %s: status %d: %s", req.path, rec.Code, rec.Body)
+		}
+	}
+	s.bernie.pool("g").SetArtifactDir(dir)
+	attempt := filepath.Join(dir, "a", "1")
+	if err := os.MkdirAll(filepath.Join(attempt, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(attempt, "sub", "out.txt"), []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{path: "sub/out.txt", status: 200, body: "hello\n"},
+		{path: "sub", status: 404},
+		{path: "sub/", status: 404},
+		{path: "missing", status: 404},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/tasks/g/a/artifacts/1/"+test.path, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.path, rec.Code, test.status)
+		}
+		if test.status == 200 && rec.Body.String() != test.body {
+			t.Errorf("%s: body %q, want %q", test.path, rec.Body, test.body)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestLegacyRoutes pins the status codes and bodies of the routes without /v1.
+// The requests run in order against one server.
+func TestLegacyRoutes(t *testing.T) {
+	log := discardLogger()
+	s := &handler{log: log, bernie: bernieServer{log: log}}
+	s.bernie.init()
+	r := mux.NewRouter()
+	s.register(r)
+
+	const (
+		jsonBody = iota
+		textBody
+		// subsetBody is for JSON replies that may have more fields than want.
+		subsetBody
+	)
+	tests := []struct {
+		method, path, body string
+		status             int
+		kind               int
+		want               string
+	}{
+		{"POST", "/groups/add", `{"name": "g", "init": {"cmd": ["true"]}}`, 200, jsonBody, `{"success": true}`},
+		{"POST", "/groups/add", `{"name": "g", "init": {"cmd": ["true"]}}`, 409, jsonBody, `{"success": false, "reason": "cannot create existing group"}`},
+		{"POST", "/groups/add", `{"name": "h"}`, 400, jsonBody, `{"success": false, "reason": "need init task"}`},
+		{"POST", "/groups/add", `{`, 400, jsonBody, `{"success": false, "reason": "error decoding request"}`},
+
+		{"POST", "/tasks/g/add", `{"tasks": [{"name": "a", "cmd": ["true"]}]}`, 200, jsonBody, `{"success": true, "reason": "", "added": ["a"], "exist": []}`},
+		{"POST", "/tasks/g/add", `{"tasks": [{"name": "a", "cmd": ["true"]}]}`, 200, jsonBody, `{"success": false, "reason": "", "added": [], "exist": ["a"]}`},
+		{"POST", "/tasks/x/add", `{"tasks": [{"name": "a", "cmd": ["true"]}]}`, 200, jsonBody, `{"success": false, "reason": "group does not exist", "added": [], "exist": []}`},
+		{"POST", "/tasks/g/add", `{`, 400, jsonBody, `{"success": false, "reason": "error decoding request"}`},
+
+		{"PATCH", "/tasks/g/a?status-tries=0", "", 200, jsonBody, `{"success": true}`},
+		{"PATCH", "/tasks/g/a", "", 400, jsonBody, `{"success": false, "reason": "unknown or unprovided field"}`},
+		{"PATCH", "/tasks/g/b?status-tries=0", "", 404, jsonBody, `{"success": false, "reason": "unknown group or task"}`},
+		{"GET", "/tasks/g/a/manifest", "", 200, subsetBody, `{"name": "a", "cmd": ["true"], "env": [], "wd": "", "status": {"tmux": {"session": ""}}}`},
+		{"GET", "/tasks/g/b/manifest", "", 404, textBody, "unknown group or task\n"},
+		{"GET", "/tasks/g/b/out", "", 404, textBody, "unknown group or task\n"},
+
+		{"DELETE", "/tasks/g/a", "", 200, jsonBody, `{"success": true}`},
+		{"DELETE", "/tasks/g/a", "", 200, jsonBody, `{"success": true}`},
+		{"DELETE", "/tasks/x/a", "", 404, jsonBody, `{"success": false, "reason": "group does not exist"}`},
+
+		{"POST", "/workers/g/add", `{"workers": []}`, 200, jsonBody, `{"success": true}`},
+		{"POST", "/workers/x/add", `{"workers": []}`, 404, jsonBody, `{"success": false, "reason": "group does not exist"}`},
+		{"PATCH", "/workers/g/w?status-failedtasks=0", "", 404, jsonBody, `{"success": false, "reason": "unknown group or worker"}`},
+		{"PATCH", "/workers/g/w", "", 400, jsonBody, `{"success": false, "reason": "unknown or unprovided field"}`},
+		{"GET", "/workers/g/w/manifest", "", 404, textBody, "unknown group or worker\n"},
+		{"GET", "/workers/g/w/initout", "", 404, textBody, "unknown group or worker\n"},
+		{"DELETE", "/workers/g/w", "", 200, jsonBody, `{"success": true}`},
+		{"DELETE", "/workers/x/w", "", 404, jsonBody, `{"success": false, "reason": "unknown group"}`},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		name := test.method + " " + test.path
+		if rec.Code != test.status {
+			t.Errorf("%s: status %d, want %d", name, rec.Code, test.status)
+		}
+		if test.kind == textBody {
+			if got := rec.Body.String(); got != test.want {
+				t.Errorf("%s: body %q, want %q", name, got, test.want)
+			}
+			continue
+		}
+		var got, want interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("%s: bad JSON body %q: %v", name, rec.Body.String(), err)
+			continue
+		}
+		if err := json.Unmarshal([]byte(test.want), &want); err != nil {
+			t.Fatalf("%s: bad want: %v", name, err)
+		}
+		if test.kind == subsetBody {
+			got = pick(got, want)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: body %s, want %s", name, rec.Body.String(), test.want)
+		}
+	}
+}
+
+// pick returns the fields of v that are in like, recursively.
+func pick(v, like interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	lm, lok := like.(map[string]interface{})
+	if !ok || !lok {
+		return v
+	}
+	out := make(map[string]interface{})
+	for k, l := range lm {
+		if x, ok := m[k]; ok {
+			out[k] = pick(x, l)
+		}
+	}
+	return out
+}
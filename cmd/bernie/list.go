@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/uluyol/bernie"
+)
+
+type taskStatusView struct {
+	State     string `json:"state"`
+	Summary   string `json:"summary"`
+	Tries     int    `json:"tries"`
+	Err       string `json:"err,omitempty"`
+	Runner    string `json:"runner,omitempty"`
+	Suspended bool   `json:"suspended"`
+	Tmux      struct {
+		Session string `json:"session"`
+	} `json:"tmux"`
+	FailedOn   []string      `json:"failedon"`
+	Attempts   []attemptView `json:"attempts"`
+	CacheKey   string        `json:"cachekey,omitempty"`
+	CachedFrom string        `json:"cachedfrom,omitempty"`
+}
+
+type taskView struct {
+	Name    string             `json:"name"`
+	Cmd     []string           `json:"cmd"`
+	Env     []string           `json:"env"`
+	WD      string             `json:"wd"`
+	Outputs []string           `json:"outputs"`
+	Cache   *bernie.CacheSpec  `json:"cache,omitempty"`
+	Exit    *bernie.ExitPolicy `json:"exit,omitempty"`
+	Labels  map[string]string  `json:"labels,omitempty"`
+	Status  taskStatusView     `json:"status"`
+}
+
+func newTaskView(p *bernie.WorkerPool, t *bernie.Task) taskView {
+	v := taskView{
+		Name:    t.Name,
+		Cmd:     t.Cmd,
+		Env:     bernie.RedactEnv(t.Env, p.SecretKeys()),
+		WD:      t.WD,
+		Outputs: t.Outputs,
+		Cache:   t.Cache,
+		Exit:    t.Exit,
+		Labels:  t.Labels,
+	}
+	st := t.Status()
+	v.Status.State = taskState(st, p.AllowableTaskTries())
+	v.Status.Summary = st.HumanFriendly(p.AllowableTaskTries())
+	v.Status.Tries = st.Tries
+	if st.Err != nil {
+		v.Status.Err = st.Err.Error()
+	}
+	if st.Runner != nil {
+		v.Status.Runner = st.Runner.Name()
+	}
+	v.Status.Suspended = st.Suspended
+	v.Status.Tmux.Session = st.Tmux.Session
+	v.Status.CacheKey = st.CacheKey
+	v.Status.CachedFrom = st.CachedFrom
+	for _, w := range p.FailedOn(t) {
+		v.Status.FailedOn = append(v.Status.FailedOn, w.Name())
+	}
+	for _, a := range st.Attempts {
+		v.Status.Attempts = append(v.Status.Attempts, newAttemptView(a))
+	}
+	return v
+}
+
+type workerView struct {
+	Name        string `json:"name"`
+	Manifest    string `json:"manifest"`
+	State       string `json:"state"`
+	FailedTasks int    `json:"failedtasks"`
+	Initialized bool   `json:"initialized"`
+	InitVersion int    `json:"initversion,omitempty"`
+	RunningTask string `json:"runningtask,omitempty"`
+}
+
+// newWorkerView describes w, which is in p, or in the shared pool if p is nil.
+func (s *handler) newWorkerView(p *bernie.WorkerPool, w *bernie.Worker) workerView {
+	maxFails := s.bernie.shared.AllowableWorkerFailures()
+	if p != nil {
+		maxFails = p.AllowableWorkerFailures()
+	}
+	st := w.Status()
+	v := workerView{
+		Name:        w.Name(),
+		Manifest:    w.Manifest(),
+		State:       st.HumanFriendly(maxFails),
+		FailedTasks: st.FailedTasks,
+		Initialized: st.Initialized,
+	}
+	if p != nil {
+		v.InitVersion = p.WorkerInitVersion(w)
+	}
+	if st.RunningTask != nil {
+		v.RunningTask = st.RunningTask.Name
+	}
+	return v
+}
+
+type groupView struct {
+	Name           string                `json:"name"`
+	Share          float64               `json:"share"`
+	StrictRetries  bool                  `json:"strictretries"`
+	MaxTries       int                   `json:"maxtries"`
+	MaxFails       int                   `json:"maxfails"`
+	InitVersion    int                   `json:"initversion"`
+	Paused         pauseView             `json:"paused"`
+	Env            []string              `json:"env"`
+	Secrets        []string              `json:"secrets"`
+	ManifestSchema bernie.ManifestSchema `json:"manifestschema,omitempty"`
+	Tasks          map[string]int        `json:"tasks"`
+	Workers        int                   `json:"workers"`
+}
+
+func newGroupView(g Group) groupView {
+	p := g.Pool
+	secretKeys := p.SecretKeys()
+	v := groupView{
+		Name:           g.Name,
+		Share:          p.Share(),
+		StrictRetries:  p.StrictRetries(),
+		MaxTries:       p.AllowableTaskTries(),
+		MaxFails:       p.AllowableWorkerFailures(),
+		InitVersion:    p.InitVersion(),
+		Env:            bernie.RedactEnv(p.Env(), secretKeys),
+		Secrets:        secretKeys,
+		ManifestSchema: g.ManifestSchema,
+		Tasks:          make(map[string]int),
+		Workers:        len(p.WorkersCopy()),
+	}
+	if info := p.Paused(); info != nil {
+		v.Paused = pauseView{true, info}
+	}
+	for _, state := range taskStates {
+		v.Tasks[state] = 0
+	}
+	for _, t := range g.Tasks {
+		v.Tasks[taskState(t.Status(), v.MaxTries)]++
+	}
+	return v
+}
+
+// listQuery holds the filters and pagination of a listing request.
+//
+// Objects are listed in order of name.
+// The cursor is opaque to clients and resumes the listing
+// after the last object of the previous page.
+type listQuery struct {
+	states map[string]bool
+	prefix string
+	limit  int
+	after  string
+}
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+var errBadCursor = errors.New("bad cursor")
+
+func parseListQuery(r *http.Request) (listQuery, error) {
+	q := r.URL.Query()
+	lq := listQuery{
+		prefix: q.Get("prefix"),
+		limit:  defaultListLimit,
+	}
+	if v := q.Get("state"); v != "" {
+		lq.states = make(map[string]bool)
+		for _, s := range strings.Split(v, ",") {
+			lq.states[strings.ToLower(s)] = true
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return lq, fmt.Errorf("bad limit %q", v)
+		}
+		if n > maxListLimit {
+			n = maxListLimit
+		}
+		lq.limit = n
+	}
+	if v := q.Get("cursor"); v != "" {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return lq, errBadCursor
+		}
+		lq.after = string(b)
+	}
+	return lq, nil
+}
+
+func (q *listQuery) matches(name, state string) bool {
+	if !strings.HasPrefix(name, q.prefix) || (q.after != "" && name <= q.after) {
+		return false
+	}
+	return q.states == nil || q.states[strings.ToLower(state)]
+}
+
+// page returns the number of the sorted matching objects to return
+// and the cursor for the page after them, if any.
+func (q *listQuery) page(names []string) (n int, next string) {
+	if len(names) <= q.limit {
+		return len(names), ""
+	}
+	return q.limit, base64.RawURLEncoding.EncodeToString([]byte(names[q.limit-1]))
+}
+
+// The replies of listings hold a page of objects
+// and, if there are more, the cursor of the next page.
+type (
+	groupsListResp struct {
+		Groups []groupView `json:"groups"`
+		Next   string      `json:"next,omitempty"`
+	}
+	tasksListResp struct {
+		Tasks []taskView `json:"tasks"`
+		Next  string     `json:"next,omitempty"`
+	}
+	workersListResp struct {
+		Workers []workerView `json:"workers"`
+		Next    string       `json:"next,omitempty"`
+	}
+)
+
+// Possible paths:
+// /groups
+func (s *handler) groupsListHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := s.listQuery(w, r)
+	if !ok {
+		return
+	}
+	var views []groupView
+	var names []string
+	for _, g := range s.bernie.Groups() {
+		state := "active"
+		if g.Pool.Paused() != nil {
+			state = "paused"
+		}
+		if q.matches(g.Name, state) {
+			views = append(views, newGroupView(g))
+			names = append(names, g.Name)
+		}
+	}
+	n, next := q.page(names)
+	s.writeJSON(w, r, groupsListResp{append([]groupView{}, views[:n]...), next})
+}
+
+// Possible paths:
+// /groups/{group}
+func (s *handler) groupsGetHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	for _, g := range s.bernie.Groups() {
+		if g.Name == name {
+			s.writeJSON(w, r, newGroupView(g))
+			return
+		}
+	}
+	s.fail(w, r, codeNotFound, errGroupNotExist.Error())
+}
+
+// Possible paths:
+// /tasks/{group}
+func (s *handler) tasksListHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	p := s.bernie.pool(group)
+	if p == nil {
+		s.fail(w, r, codeNotFound, errGroupNotExist.Error())
+		return
+	}
+	q, ok := s.listQuery(w, r)
+	if !ok {
+		return
+	}
+	ts := s.bernie.Tasks(group)
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Name < ts[j].Name })
+	var matched []*bernie.Task
+	var names []string
+	for _, t := range ts {
+		if q.matches(t.Name, taskState(t.Status(), p.AllowableTaskTries())) {
+			matched = append(matched, t)
+			names = append(names, t.Name)
+		}
+	}
+	n, next := q.page(names)
+	views := make([]taskView, n)
+	for i, t := range matched[:n] {
+		views[i] = newTaskView(p, t)
+	}
+	s.writeJSON(w, r, tasksListResp{views, next})
+}
+
+// Possible paths:
+// /tasks/{group}/{task}
+func (s *handler) tasksGetHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	t, ok := getTask(s.bernie.Tasks(vars["group"]), vars["task"])
+	// The group may have been removed since its tasks were looked up.
+	p := s.bernie.pool(vars["group"])
+	if !ok || p == nil {
+		s.fail(w, r, codeNotFound, "unknown group or task")
+		return
+	}
+	s.writeJSON(w, r, newTaskView(p, t))
+}
+
+// Possible paths:
+// /workers/{group}
+// /shared/workers
+func (s *handler) workersListHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.workersPool(w, r)
+	if !ok {
+		return
+	}
+	q, ok := s.listQuery(w, r)
+	if !ok {
+		return
+	}
+	ws := s.workers(r)
+	sort.Slice(ws, func(i, j int) bool { return ws[i].Name() < ws[j].Name() })
+	var views []workerView
+	var names []string
+	for _, wk := range ws {
+		v := s.newWorkerView(p, wk)
+		if q.matches(v.Name, v.State) {
+			views = append(views, v)
+			names = append(names, v.Name)
+		}
+	}
+	n, next := q.page(names)
+	s.writeJSON(w, r, workersListResp{append([]workerView{}, views[:n]...), next})
+}
+
+// Possible paths:
+// /workers/{group}/{worker}
+// /shared/workers/{worker}
+func (s *handler) workersGetHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.workersPool(w, r)
+	if !ok {
+		return
+	}
+	if wk, ok := getWorker(s.workers(r), mux.Vars(r)["worker"]); ok {
+		s.writeJSON(w, r, s.newWorkerView(p, wk))
+		return
+	}
+	s.fail(w, r, codeNotFound, "unknown group or worker")
+}
+
+// workersPool returns the pool of the group named in the request,
+// or nil for routes without a group.
+func (s *handler) workersPool(w http.ResponseWriter, r *http.Request) (*bernie.WorkerPool, bool) {
+	group, ok := mux.Vars(r)["group"]
+	if !ok {
+		return nil, true
+	}
+	p := s.bernie.pool(group)
+	if p == nil {
+		s.fail(w, r, codeNotFound, errGroupNotExist.Error())
+		return nil, false
+	}
+	return p, true
+}
+
+func (s *handler) listQuery(w http.ResponseWriter, r *http.Request) (listQuery, bool) {
+	q, err := parseListQuery(r)
+	if err != nil {
+		s.fail(w, r, codeInvalid, err.Error())
+		return q, false
+	}
+	return q, true
+}
+
+// writeJSON writes v as indented JSON.
+func (s *handler) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	s.writeJSONStatus(w, r, http.StatusOK, v)
+}
+
+// writeJSONStatus is like writeJSON but replies with status.
+func (s *handler) writeJSONStatus(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"err":  err,
+			"path": r.URL.Path,
+		}).Error("unable to encode response")
+		w.Header().Set("content-type", "text/plain")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "unable to encode response")
+		return
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", "  "); err != nil {
+		s.log.WithFields(logrus.Fields{
+			"err":  err,
+			"path": r.URL.Path,
+		}).Error("unable to indent json")
+		buf.Reset()
+		buf.Write(b)
+	}
+	buf.WriteByte('\n')
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
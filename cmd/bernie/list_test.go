@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseListQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		limit   int
+		wantErr bool
+	}{
+		{query: "", limit: defaultListLimit},
+		{query: "limit=5", limit: 5},
+		{query: "limit=100000", limit: maxListLimit},
+		{query: "limit=0", wantErr: true},
+		{query: "limit=-1", wantErr: true},
+		{query: "limit=x", wantErr: true},
+		{query: "cursor=%21%21", wantErr: true},
+	}
+	for _, test := range tests {
+		q, err := parseListQuery(httptest.NewRequest("GET", "/v1/groups?"+test.query, nil))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseListQuery(%q) = %+v, want an error", test.query, q)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseListQuery(%q): %v", test.query, err)
+			continue
+		}
+		if q.limit != test.limit {
+			t.Errorf("parseListQuery(%q).limit = %d, want %d", test.query, q.limit, test.limit)
+		}
+	}
+}
+
+func TestListQueryPaging(t *testing.T) {
+	names := []string{"a-1", "a-2", "a-3", "b-1", "b-2"}
+	states := map[string]string{"a-1": "failed", "a-2": "queued", "a-3": "failed", "b-1": "failed", "b-2": "Killed"}
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  [][]string
+	}{
+		{
+			name:  "one page",
+			query: url.Values{},
+			want:  [][]string{{"a-1", "a-2", "a-3", "b-1", "b-2"}},
+		},
+		{
+			name:  "exact pages",
+			query: url.Values{"limit": {"5"}},
+			want:  [][]string{{"a-1", "a-2", "a-3", "b-1", "b-2"}},
+		},
+		{
+			name:  "pages of two",
+			query: url.Values{"limit": {"2"}},
+			want:  [][]string{{"a-1", "a-2"}, {"a-3", "b-1"}, {"b-2"}},
+		},
+		{
+			name:  "prefix",
+			query: url.Values{"limit": {"2"}, "prefix": {"a-"}},
+			want:  [][]string{{"a-1", "a-2"}, {"a-3"}},
+		},
+		{
+			name:  "states",
+			query: url.Values{"limit": {"1"}, "state": {"failed,KILLED"}},
+			want:  [][]string{{"a-1"}, {"a-3"}, {"b-1"}, {"b-2"}},
+		},
+		{
+			name:  "no match",
+			query: url.Values{"prefix": {"c"}},
+			want:  [][]string{nil},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got [][]string
+			cursor := ""
+			for page := 0; page <= len(names); page++ {
+				v := url.Values{}
+				for k, vs := range test.query {
+					v[k] = vs
+				}
+				if cursor != "" {
+					v.Set("cursor", cursor)
+				}
+				q, err := parseListQuery(httptest.NewRequest("GET", "/v1/tasks/g?"+v.Encode(), nil))
+				if err != nil {
+					t.Fatalf("parseListQuery(%s): %v", v.Encode(), err)
+				}
+				var matched []string
+				for _, name := range names {
+					if q.matches(name, states[name]) {
+						matched = append(matched, name)
+					}
+				}
+				n, next := q.page(matched)
+				got = append(got, matched[:n])
+				if next == "" {
+					break
+				}
+				if strings.ContainsAny(next, "/+=") {
+					t.Errorf("cursor %q is not URL safe", next)
+				}
+				cursor = next
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got pages %q, want %q", got, test.want)
+			}
+		})
+	}
+}
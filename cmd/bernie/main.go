@@ -5,6 +5,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -15,6 +16,10 @@ var (
 	addr     = flag.String("addr", ":8080", "addr to serve on (port 0 auto-assigns a port)")
 	maxTries = flag.Int("maxtries", 4, "max allowable tries for a task")
 	maxFails = flag.Int("maxfailures", 3, "max allowed failures on worker")
+
+	artifactRoot  = flag.String("artifacts", "", "dir to keep task outputs in, empty to not keep them")
+	cgroupRoot    = flag.String("cgroup", "", "writable cgroup v2 dir to account task usage in, empty to use process groups")
+	shareHalfLife = flag.Duration("sharehalflife", time.Hour, "half-life of group usage when sharing workers")
 )
 
 func main() {
@@ -43,17 +48,7 @@ func main() {
 		ErrorLog: log.New(httpLW, "", 0),
 	}
 	r.HandleFunc("/", handler.rootHandler).Methods("GET")
-	r.HandleFunc("/groups/add", handler.groupsAddHandler).Methods("POST")
-	r.HandleFunc("/tasks/{group}/add", handler.tasksAddHandler).Methods("POST")
-	r.HandleFunc("/tasks/{group}/{task}", handler.tasksDeleteHandler).Methods("DELETE")
-	r.HandleFunc("/tasks/{group}/{task}", handler.tasksPatchHandler).Methods("PATCH")
-	r.HandleFunc("/tasks/{group}/{task}/manifest", handler.tasksManifestHandler).Methods("GET")
-	r.HandleFunc("/tasks/{group}/{task}/out", handler.tasksOutHandler).Methods("GET")
-	r.HandleFunc("/workers/{group}/add", handler.workersAddHandler).Methods("POST")
-	r.HandleFunc("/workers/{group}/{worker}", handler.workersDeleteHandler).Methods("DELETE")
-	r.HandleFunc("/workers/{group}/{worker}", handler.workersPatchHandler).Methods("PATCH")
-	r.HandleFunc("/workers/{group}/{worker}/manifest", handler.workersManifestHandler).Methods("GET")
-	r.HandleFunc("/workers/{group}/{worker}/initout", handler.workersInitOutHandler).Methods("GET")
+	handler.register(r)
 	l, err := net.Listen("tcp", *addr)
 	if err != nil {
 		logger.Fatalf("failed to listen on %s: %v", *addr, err)
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/uluyol/bernie"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the duration histograms.
+var durationBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 3 * 3600, 12 * 3600, 24 * 3600}
+
+type histogram struct {
+	counts []int // per bucket, not cumulative
+	count  int
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]int, len(durationBuckets))
+	}
+	h.count++
+	h.sum += v
+	for i, b := range durationBuckets {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+}
+
+// eventMetrics are the counters and histograms of a group.
+// They are updated as attempts finish rather than computed from the tasks
+// that exist when they are scraped, so that removing tasks or groups
+// does not make them go down.
+type eventMetrics struct {
+	mu     sync.Mutex
+	groups map[string]*groupMetrics
+}
+
+type groupMetrics struct {
+	retries   int
+	durations histogram
+	inits     histogram
+}
+
+// group returns the metrics of the group called name, creating them if needed.
+//
+// Make sure that m.mu is held before calling this method!
+func (m *eventMetrics) group(name string) *groupMetrics {
+	if m.groups == nil {
+		m.groups = make(map[string]*groupMetrics)
+	}
+	g := m.groups[name]
+	if g == nil {
+		g = new(groupMetrics)
+		m.groups[name] = g
+	}
+	return g
+}
+
+// taskEvent records the attempt that ended with ev.
+func (m *eventMetrics) taskEvent(group string, ev bernie.Event) {
+	a := ev.Status.LastAttempt()
+	if ev.Kind == bernie.QueueEmpty || a == nil || a.CachedFrom != "" || ev.Status.Err == bernie.ErrNoWorkerLeft {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g := m.group(group)
+	g.durations.observe(attemptSeconds(*a))
+	if ev.Kind == bernie.TaskRetrying {
+		g.retries++
+	}
+}
+
+// initRan records an attempt of the init task of group.
+func (m *eventMetrics) initRan(group string, a bernie.Attempt) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.group(group).inits.observe(attemptSeconds(a))
+}
+
+// write adds the metrics of every group that has had any to w.
+func (m *eventMetrics) write(w *metricsWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.groups))
+	for name := range m.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g := m.groups[name]
+		w.family("bernie_task_retries_total", "counter", "Number of failed task attempts that were retried.").
+			sample("bernie_task_retries_total", float64(g.retries), "group", name)
+		w.family("bernie_task_duration_seconds", "histogram", "Duration of task attempts, excluding time spent suspended.").
+			histogram("bernie_task_duration_seconds", &g.durations, "group", name)
+		w.family("bernie_init_duration_seconds", "histogram", "Duration of init task attempts, including those on shared workers.").
+			histogram("bernie_init_duration_seconds", &g.inits, "group", name)
+	}
+}
+
+// metricsWriter collects metrics and writes them in the Prometheus text format.
+type metricsWriter struct {
+	order    []string
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	typ, help string
+	samples   bytes.Buffer
+}
+
+// family returns the family called name, creating it if needed.
+func (w *metricsWriter) family(name, typ, help string) *metricFamily {
+	if w.families == nil {
+		w.families = make(map[string]*metricFamily)
+	}
+	f := w.families[name]
+	if f == nil {
+		f = &metricFamily{typ: typ, help: help}
+		w.families[name] = f
+		w.order = append(w.order, name)
+	}
+	return f
+}
+
+func (w *metricsWriter) WriteTo(out io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for _, name := range w.order {
+		f := w.families[name]
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.typ)
+		buf.Write(f.samples.Bytes())
+	}
+	return buf.WriteTo(out)
+}
+
+// sample adds one sample called name; labels alternates label names and values.
+func (f *metricFamily) sample(name string, v float64, labels ...string) {
+	f.samples.WriteString(name)
+	if len(labels) > 0 {
+		f.samples.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				f.samples.WriteByte(',')
+			}
+			fmt.Fprintf(&f.samples, "%s=%s", labels[i], labelValue(labels[i+1]))
+		}
+		f.samples.WriteByte('}')
+	}
+	f.samples.WriteByte(' ')
+	f.samples.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	f.samples.WriteByte('\n')
+}
+
+func (f *metricFamily) histogram(name string, h *histogram, labels ...string) {
+	if h.counts == nil {
+		h.counts = make([]int, len(durationBuckets))
+	}
+	cum := 0
+	for i, b := range durationBuckets {
+		cum += h.counts[i]
+		f.sample(name+"_bucket", float64(cum), append(labels[:len(labels):len(labels)], "le", strconv.FormatFloat(b, 'g', -1, 64))...)
+	}
+	f.sample(name+"_bucket", float64(h.count), append(labels[:len(labels):len(labels)], "le", "+Inf")...)
+	f.sample(name+"_sum", h.sum, labels...)
+	f.sample(name+"_count", float64(h.count), labels...)
+}
+
+func labelValue(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(v) + `"`
+}
+
+// taskState is the state of a task as reported by /metrics.
+func taskState(st bernie.TaskStatus, maxTries int) string {
+	switch {
+	case st.IsRunning():
+		return "running"
+	case st.Killed:
+		return "killed"
+	case st.Done && st.Err == nil:
+		return "succeeded"
+	case st.Done && (st.FailedPermanently() || st.Tries >= maxTries):
+		return "failed"
+	}
+	return "queued"
+}
+
+var taskStates = []string{"queued", "running", "succeeded", "failed", "killed"}
+
+func attemptSeconds(a bernie.Attempt) float64 {
+	return (a.End.Sub(a.Start) - a.Suspended).Seconds()
+}
+
+// Possible paths:
+// /metrics
+func (s *handler) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var m metricsWriter
+	for _, g := range s.bernie.Groups() {
+		maxTries := g.Pool.AllowableTaskTries()
+		maxFails := g.Pool.AllowableWorkerFailures()
+
+		states := make(map[string]int)
+		for _, t := range g.Tasks {
+			states[taskState(t.Status(), maxTries)]++
+		}
+		tasks := m.family("bernie_tasks", "gauge", "Number of tasks by state.")
+		for _, state := range taskStates {
+			tasks.sample("bernie_tasks", float64(states[state]), "group", g.Name, "state", state)
+		}
+
+		workers := make(map[string]int)
+		for _, wk := range g.Pool.WorkersCopy() {
+			workers[wk.Status().HumanFriendly(maxFails)]++
+		}
+		f := m.family("bernie_workers", "gauge", "Number of workers by state.")
+		for _, state := range sortedKeys(workers) {
+			f.sample("bernie_workers", float64(workers[state]), "group", g.Name, "state", state)
+		}
+	}
+	s.bernie.metrics.write(&m)
+
+	shared := make(map[string]int)
+	maxFails := s.bernie.shared.AllowableWorkerFailures()
+	for _, wk := range s.bernie.SharedWorkers() {
+		shared[wk.Status().HumanFriendly(maxFails)]++
+	}
+	f := m.family("bernie_shared_workers", "gauge", "Number of shared workers by state.")
+	for _, state := range sortedKeys(shared) {
+		f.sample("bernie_shared_workers", float64(shared[state]), "state", state)
+	}
+
+	w.Header().Add("content-type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
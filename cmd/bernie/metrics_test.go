@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/uluyol/bernie"
+)
+
+func TestTaskState(t *testing.T) {
+	const maxTries = 3
+	failure := errors.New("exit status 1")
+	tests := []struct {
+		name  string
+		st    bernie.TaskStatus
+		want  string
+		final bool
+	}{
+		{name: "new", st: bernie.TaskStatus{}, want: "queued"},
+		{name: "running", st: bernie.TaskStatus{Runner: &bernie.Worker{}}, want: "running"},
+		{name: "succeeded", st: bernie.TaskStatus{Done: true}, want: "succeeded", final: true},
+		{name: "retrying", st: bernie.TaskStatus{Done: true, Err: failure, Tries: 1}, want: "queued"},
+		{name: "out of tries", st: bernie.TaskStatus{Done: true, Err: failure, Tries: maxTries}, want: "failed", final: true},
+		{name: "permanent", st: bernie.TaskStatus{Done: true, Err: &bernie.ExitError{Code: 2, Permanent: true}, Tries: 1}, want: "failed", final: true},
+		{name: "killed while queued", st: bernie.TaskStatus{Killed: true}, want: "killed", final: true},
+		{name: "killed while running", st: bernie.TaskStatus{Killed: true, Done: true, Tries: 1, Runner: &bernie.Worker{}}, want: "killed", final: true},
+	}
+	for _, test := range tests {
+		got := taskState(test.st, maxTries)
+		if got != test.want {
+			t.Errorf("%s: taskState = %q, want %q", test.name, got, test.want)
+		}
+		if isFinalState(got) != test.final {
+			t.Errorf("%s: isFinalState(%q) = %t, want %t", test.name, got, !test.final, test.final)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPI describes the v1 routes as an OpenAPI 3.0 document.
+// Schemas are generated from the types of the request and reply bodies of each route.
+func openAPI() map[string]interface{} {
+	g := schemaGen{defs: make(map[string]interface{})}
+	errResp := map[string]interface{}{
+		"description": "error",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(apiErrorResp{}))},
+		},
+	}
+
+	paths := make(map[string]map[string]interface{})
+	for _, rt := range routes {
+		p, params := pathParams(rt.path)
+		for _, name := range rt.query {
+			q := queryParams[name]
+			params = append(params, map[string]interface{}{
+				"name":        name,
+				"in":          "query",
+				"description": q.desc,
+				"schema":      map[string]interface{}{"type": q.typ},
+			})
+		}
+		op := map[string]interface{}{
+			"summary": rt.summary,
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if rt.req != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": !rt.optional,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(rt.req))},
+				},
+			}
+		}
+		status := rt.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ok := map[string]interface{}{"description": http.StatusText(status)}
+		switch resp := rt.resp.(type) {
+		case nil:
+		case string:
+			schema := map[string]interface{}{"type": "string"}
+			if resp == "application/json" {
+				schema = map[string]interface{}{"type": "object"}
+			} else if !strings.HasPrefix(resp, "text/") {
+				schema["format"] = "binary"
+			}
+			ok["content"] = map[string]interface{}{resp: map[string]interface{}{"schema": schema}}
+		default:
+			ok["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(resp))},
+			}
+		}
+		op["responses"] = map[string]interface{}{
+			strconv.Itoa(status): ok,
+			"default":            errResp,
+		}
+		if paths[p] == nil {
+			paths[p] = make(map[string]interface{})
+		}
+		paths[p][strings.ToLower(rt.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "bernie",
+			"version": "1",
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": "/v1"}},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": g.defs},
+	}
+}
+
+var pathVarRE = regexp.MustCompile(`\{(\w+)(:[^}]*)?\}`)
+
+// pathParams converts the mux variables in p to OpenAPI path parameters.
+func pathParams(p string) (string, []interface{}) {
+	var params []interface{}
+	for _, m := range pathVarRE.FindAllStringSubmatch(p, -1) {
+		params = append(params, map[string]interface{}{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	return pathVarRE.ReplaceAllString(p, "{$1}"), params
+}
+
+// schemaGen generates the JSON schemas of Go types as encoding/json encodes them.
+// Named struct types are added to defs and referred to.
+type schemaGen struct {
+	defs map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // in case t refers to itself
+			g.defs[t.Name()] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func (g *schemaGen) object(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	g.fields(t, props)
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// fields adds the schemas of the fields of the struct type t to props,
+// including those of embedded structs.
+func (g *schemaGen) fields(t reflect.Type, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			g.fields(ft, props)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+	}
+}
+
+// Possible paths:
+// /v1/openapi.json
+func (s *handler) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, r, s.openapi)
+}
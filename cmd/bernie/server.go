@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,6 +19,11 @@ type Group struct {
 	Pool     *bernie.WorkerPool
 	Tasks    []*bernie.Task
 	TasksSet map[string]struct{}
+
+	// ManifestSchema is set if the group's workers have structured manifests.
+	ManifestSchema bernie.ManifestSchema
+
+	Webhooks *webhookSet
 }
 
 func (s *bernieServer) newGroup(name string, maxFails, maxTries int, initTask *bernie.Task) *Group {
@@ -24,11 +31,30 @@ func (s *bernieServer) newGroup(name string, maxFails, maxTries int, initTask *b
 		"group": name,
 		"elem":  "wpool",
 	})
-	return &Group{
+	g := &Group{
 		Name:     name,
 		Pool:     bernie.NewWorkerPool(pl, maxFails, maxTries, initTask),
 		TasksSet: make(map[string]struct{}),
+		Webhooks: new(webhookSet),
+	}
+	g.Pool.SetEventHandler(func(ev bernie.Event) {
+		s.metrics.taskEvent(name, ev)
+		s.notify(g, ev)
+	})
+	g.Pool.SetInitHandler(func(w *bernie.Worker, a bernie.Attempt) {
+		s.metrics.initRan(name, a)
+	})
+	if *artifactRoot != "" {
+		if bernie.IsPathElem(name) {
+			// A group that is removed and added again gets a new dir
+			// so that the artifacts of the old one are kept.
+			created := time.Now().UTC().Format("20060102T150405.000000000Z")
+			g.Pool.SetArtifactDir(filepath.Join(*artifactRoot, name, created))
+		} else {
+			pl.Errorf("group name cannot be used as a dir, not keeping artifacts")
+		}
 	}
+	return g
 }
 
 func (g *Group) HasTask(name string) bool {
@@ -41,50 +67,181 @@ type bernieServer struct {
 
 	mu      sync.RWMutex
 	groups  map[string]*Group
+	shared  *bernie.SharedPool
 	nameGen batchNameGen
+	metrics eventMetrics
 }
 
 func (s *bernieServer) init() {
 	s.groups = make(map[string]*Group)
+	s.shared = bernie.NewSharedPool(s.log.WithField("elem", "shared-wpool"), *maxFails, *shareHalfLife)
 }
 
 var errGroupNotExist = errors.New("group does not exist")
 
-func (s *bernieServer) addGroup(group string, init *bernie.Task) bool {
+// groupOpts are the optional settings of a group.
+type groupOpts struct {
+	// If Share is positive, the group is also served by the shared workers
+	// with Share as its weight.
+	Share float64 `json:"share"`
+	// If StrictRetries is set, failed tasks never go back to a worker they failed on.
+	StrictRetries bool `json:"strictretries"`
+	// Exit is the exit policy of tasks that do not have their own.
+	Exit *bernie.ExitPolicy `json:"exit"`
+	// Env holds KEY=VALUE entries given to every task and the init task.
+	Env []string `json:"env"`
+	// Secrets are variables given to every task and the init task
+	// that are never shown by the server.
+	Secrets map[string]string `json:"secrets"`
+	// ManifestSchema makes the group's worker manifests structured.
+	ManifestSchema bernie.ManifestSchema `json:"manifestschema"`
+	// MaxTries and MaxFails override the server's -maxtries and -maxfailures
+	// flags if positive.
+	MaxTries int `json:"maxtries"`
+	MaxFails int `json:"maxfails"`
+}
+
+func (o *groupOpts) validate() error {
+	if o.Share < 0 {
+		return errors.New("share must not be negative")
+	}
+	if o.MaxTries < 0 || o.MaxFails < 0 {
+		return errors.New("maxtries and maxfails must not be negative")
+	}
+	if o.Exit != nil {
+		if err := o.Exit.Validate(); err != nil {
+			return fmt.Errorf("bad exit policy: %v", err)
+		}
+	}
+	for _, kv := range o.Env {
+		if !strings.Contains(kv, "=") {
+			return fmt.Errorf("env entry %q is not KEY=VALUE", kv)
+		}
+	}
+	if err := o.ManifestSchema.Validate(); err != nil {
+		return fmt.Errorf("bad manifest schema: %v", err)
+	}
+	return validateSecrets(o.Secrets)
+}
+
+func validateSecrets(secrets map[string]string) error {
+	for k := range secrets {
+		if k == "" || strings.Contains(k, "=") {
+			return fmt.Errorf("bad secret name %q", k)
+		}
+	}
+	return nil
+}
+
+func (s *bernieServer) addGroup(group string, init *bernie.Task, opts groupOpts) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if _, ok := s.groups[group]; ok {
 		return false
 	}
-	s.groups[group] = s.newGroup(group, *maxFails, *maxTries, init)
+	fails, tries := *maxFails, *maxTries
+	if opts.MaxFails > 0 {
+		fails = opts.MaxFails
+	}
+	if opts.MaxTries > 0 {
+		tries = opts.MaxTries
+	}
+	g := s.newGroup(group, fails, tries, init)
+	g.Pool.SetStrictRetries(opts.StrictRetries)
+	g.Pool.SetExitPolicy(opts.Exit)
+	g.Pool.SetEnv(opts.Env)
+	g.Pool.SetSecrets(opts.Secrets)
+	g.ManifestSchema = opts.ManifestSchema
+	s.groups[group] = g
+	if opts.Share > 0 {
+		s.shared.Attach(g.Pool, opts.Share)
+	}
 	return true
 }
 
-func (s *bernieServer) addWorkers(group string, manifests []string) error {
+// rmGroup kills the tasks of group and removes its workers.
+func (s *bernieServer) rmGroup(group string) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	g, ok := s.groups[group]
+	delete(s.groups, group)
+	s.mu.Unlock()
+	if !ok {
+		return errGroupNotExist
+	}
+	g.Pool.SetEventHandler(nil)
+	g.Pool.SetInitHandler(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(len(g.Tasks))
+	for _, t := range g.Tasks {
+		go func(t *bernie.Task) {
+			t.Kill(ctx, false)
+			wg.Done()
+		}(t)
+	}
+	wg.Wait()
+	g.Pool.Close()
+	s.log.WithField("group", group).Info("removed group")
+	return ctx.Err()
+}
+
+func (s *bernieServer) newWorkers(manifests []string) []*bernie.Worker {
 	batch := s.nameGen.next()
 	ws := make([]*bernie.Worker, len(manifests))
 	for i, m := range manifests {
 		wname := fmt.Sprintf("%s-%03d", batch, i)
 		ws[i] = bernie.NewWorker(s.log.WithField("worker", wname), wname, m)
+		ws[i].SetCgroupRoot(*cgroupRoot)
 	}
+	return ws
+}
+
+// badManifestError is returned when a manifest does not match its group's schema.
+type badManifestError struct {
+	index int
+	err   error
+}
+
+func (e *badManifestError) Error() string {
+	return fmt.Sprintf("worker %d: %v", e.index, e.err)
+}
+
+func (s *bernieServer) addWorkers(group string, manifests []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	g, ok := s.groups[group]
 	if !ok {
 		return errGroupNotExist
 	}
+	var envs [][]string
+	if g.ManifestSchema != nil {
+		envs = make([][]string, len(manifests))
+		for i, m := range manifests {
+			env, err := g.ManifestSchema.Check(m)
+			if err != nil {
+				return &badManifestError{i, err}
+			}
+			envs[i] = env
+		}
+	}
+	ws := s.newWorkers(manifests)
+	for i := range envs {
+		ws[i].SetManifestEnv(envs[i])
+	}
 	g.Pool.Grow(ws)
 	return nil
 }
 
-func (s *bernieServer) rmWorker(group string, name string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	g, ok := s.groups[group]
-	if !ok {
-		return false
-	}
-	g.Pool.Remove(func(ws []*bernie.Worker) []int {
+func (s *bernieServer) addSharedWorkers(manifests []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shared.Add(s.newWorkers(manifests))
+}
+
+func selectWorkerNamed(name string) func([]*bernie.Worker) []int {
+	return func(ws []*bernie.Worker) []int {
 		var idx []int
 		for i, w := range ws {
 			if w.Name() == name {
@@ -92,10 +249,24 @@ func (s *bernieServer) rmWorker(group string, name string) bool {
 			}
 		}
 		return idx
-	})
+	}
+}
+
+func (s *bernieServer) rmWorker(group string, name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g, ok := s.groups[group]
+	if !ok {
+		return false
+	}
+	g.Pool.Remove(selectWorkerNamed(name))
 	return true
 }
 
+func (s *bernieServer) rmSharedWorker(name string) {
+	s.shared.Remove(selectWorkerNamed(name))
+}
+
 func (s *bernieServer) rmTask(group string, name string) error {
 	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
 	s.mu.Lock()
@@ -104,10 +275,14 @@ func (s *bernieServer) rmTask(group string, name string) error {
 	if !ok {
 		return errGroupNotExist
 	}
+	if !g.HasTask(name) {
+		return errTaskNotExist
+	}
 	for i, t := range g.Tasks {
 		if t.Name == name {
 			g.Tasks = append(g.Tasks[:i], g.Tasks[i+1:]...)
 			t.Kill(ctx, false)
+			g.Pool.Forget(t)
 		}
 		delete(g.TasksSet, name)
 	}
@@ -131,6 +306,81 @@ func (s *bernieServer) addTasks(group string, tasks []*bernie.Task) (succ, fail
 	return added, notAdded, nil
 }
 
+var errTaskNotExist = errors.New("task does not exist")
+
+func (s *bernieServer) rerunTask(group, name string) error {
+	s.mu.RLock()
+	g, ok := s.groups[group]
+	s.mu.RUnlock()
+	if !ok {
+		return errGroupNotExist
+	}
+	t, ok := getTask(s.Tasks(group), name)
+	if !ok {
+		return errTaskNotExist
+	}
+	if err := g.Pool.Rerun(t); err != nil {
+		return err
+	}
+	s.log.WithFields(logrus.Fields{
+		"group": group,
+		"task":  name,
+	}).Info("rerunning task")
+	return nil
+}
+
+// cloneOpts override the fields of a cloned task.
+type cloneOpts struct {
+	Name string   `json:"name"`
+	Cmd  []string `json:"cmd"`
+	Env  []string `json:"env"`
+	WD   string   `json:"wd"`
+}
+
+var errTaskExists = errors.New("task already exists")
+
+// cloneTask adds a copy of the named task to group and queues it.
+// Without a name in opts, the copy is named NAME-cloneN
+// for the lowest N that is not taken.
+func (s *bernieServer) cloneTask(group, name string, opts cloneOpts) (*bernie.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[group]
+	if !ok {
+		return nil, errGroupNotExist
+	}
+	t, ok := getTask(g.Tasks, name)
+	if !ok {
+		return nil, errTaskNotExist
+	}
+	c := t.FreshCopy()
+	c.Name = opts.Name
+	if c.Name == "" {
+		for i := 1; c.Name == "" || g.HasTask(c.Name); i++ {
+			c.Name = fmt.Sprintf("%s-clone%d", name, i)
+		}
+	}
+	if opts.Cmd != nil {
+		c.Cmd = opts.Cmd
+	}
+	if opts.Env != nil {
+		c.Env = opts.Env
+	}
+	if opts.WD != "" {
+		c.WD = opts.WD
+	}
+	if added, _ := g.addNewTasks([]*bernie.Task{c}); len(added) == 0 {
+		return nil, errTaskExists
+	}
+	g.Pool.Submit(c)
+	s.log.WithFields(logrus.Fields{
+		"group": group,
+		"task":  name,
+		"clone": c.Name,
+	}).Info("cloned task")
+	return c, nil
+}
+
 func (g *Group) addNewTasks(toAdd []*bernie.Task) (succ, fail []*bernie.Task) {
 	for _, t := range toAdd {
 		if g.HasTask(t.Name) {
@@ -154,6 +404,20 @@ func (s *bernieServer) Workers(group string) []*bernie.Worker {
 	return g.Pool.WorkersCopy()
 }
 
+// pool returns the worker pool of group, or nil if it does not exist.
+func (s *bernieServer) pool(group string) *bernie.WorkerPool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if g := s.groups[group]; g != nil {
+		return g.Pool
+	}
+	return nil
+}
+
+func (s *bernieServer) SharedWorkers() []*bernie.Worker {
+	return s.shared.WorkersCopy()
+}
+
 func (s *bernieServer) Tasks(group string) []*bernie.Task {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
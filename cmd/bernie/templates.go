@@ -1,6 +1,10 @@
 package main
 
-import "html/template"
+import (
+	"html/template"
+
+	"github.com/uluyol/bernie"
+)
 
 const rootTemplStr = `<!doctype html>
 <html>
@@ -26,19 +30,43 @@ const rootTemplStr = `<!doctype html>
 {{- range .Groups}}
   {{- $gname := .Name -}}
   {{- $maxFails := .Pool.AllowableWorkerFailures -}}
-  {{- $maxTries := .Pool.AllowableTaskTries}}
-  {{$gname}}
-    Tasks
+  {{- $maxTries := .Pool.AllowableTaskTries -}}
+  {{- $pool := .Pool}}
+  {{$gname}}{{with .Pool.Share}} [share {{.}}, usage {{printf "%.0f" ($.Shared.Usage $pool)}}s]{{end}}{{if not $pool.Paused}} <a href="#" onclick="apiPause('/groups/{{$gname}}/pause')">pause</a>{{end}} <a href="#" onclick="apiDelete('/groups/{{$gname}}')">rm</a>
+    Max tries {{$maxTries}}, max worker failures {{$maxFails}}
+  {{- with $pool.Paused}}
+    <b>PAUSED</b> by {{.By}} since {{.Since.Format "2006-01-02 15:04:05"}}{{with .Reason}}: {{.}}{{end}} <a href="#" onclick="apiPost('/groups/{{$gname}}/resume')">resume</a>
+  {{- end}}
+  {{- with $pool.Env}}
+    Env{{range redact . $pool.SecretKeys}} {{.}}{{end}}
+  {{- end}}
+  {{- with $pool.SecretKeys}}
+    Secrets{{range .}} {{.}}{{end}}
+  {{- end}}
+    Init version {{$pool.InitVersion}}
+  {{- with .ManifestSchema}}
+    Manifest schema{{range $k, $t := .}} {{$k}}:{{$t}}{{end}}
+  {{- end}}
+  {{- range .Webhooks.Copy}}
+    Webhook {{.ID}} {{.URL}}{{range .Events}} {{.}}{{end}}{{with .LastDelivery}} [last {{.Event}} {{if .Delivered}}delivered{{else}}undelivered after {{.Tries}} tries{{end}}]{{end}} <a href="#" onclick="apiDelete('/groups/{{$gname}}/webhooks/{{.ID}}')">rm</a>
+  {{- end}}
+    Tasks <a href="#" onclick="apiBulk('/tasks/{{$gname}}/bulk', {state: 'failed'}, 'reset-tries')">reset failed</a> <a href="#" onclick="apiBulk('/tasks/{{$gname}}/bulk', {state: 'failed'}, 'rerun')">rerun failed</a>
     {{- range .Tasks}}
       {{- $pathPre := printf "/tasks/%s/%s" $gname .Name}}
-      <a href="{{$pathPre}}/out"><b>{{.Name}}</b></a> <a href="{{$pathPre}}/manifest">manifest</a> <a href="#" onclick="apiPatch('{{$pathPre}}?status-tries=0')">reset tries</a> [{{.Status.HumanFriendly $maxTries}}] <a href="#" onclick="apiDelete('{{$pathPre}}')">rm</a>
+      <a href="{{$pathPre}}/out"><b>{{.Name}}</b></a> <a href="{{$pathPre}}/manifest">manifest</a>{{if .Outputs}} <a href="{{$pathPre}}/artifacts">artifacts</a> <a href="{{$pathPre}}/artifacts.tar">tar</a>{{end}} <a href="#" onclick="apiPatch('{{$pathPre}}?status-tries=0')">reset tries</a> [{{.Status.HumanFriendly $maxTries}}]{{if .Status.Suspended}} <a href="#" onclick="apiPost('{{$pathPre}}/resume')">resume</a>{{else if .Status.IsRunning}} <a href="#" onclick="apiPost('{{$pathPre}}/suspend')">suspend</a>{{end}}{{if or .Status.Done .Status.Killed}} <a href="#" onclick="apiPost('{{$pathPre}}/rerun')">rerun</a>{{end}}{{with .Status.LastAttempt}} [{{.Usage}}]{{end}} <a href="#" onclick="apiDelete('{{$pathPre}}')">rm</a>
     {{- end}}
-    Workers
+    Workers <a href="#" onclick="apiBulk('/workers/{{$gname}}/bulk', {state: 'dead'}, 'reset-failures')">reset dead</a>
     {{- range .Pool.WorkersCopy}}
       {{- $pathPre := printf "/workers/%s/%s" $gname .Name}}
-      <a href="{{$pathPre}}/manifest"><b>{{.Name}}</b></a> <a href="{{$pathPre}}/initout">init out</a> <a href="#" onclick="apiPatch('{{$pathPre}}?status-failedtasks=0')">reset fails</a> [{{.Status.FailedTasks}} fails, {{.Status.HumanFriendly $maxFails}}] <a href="#" onclick="apiDelete('{{$pathPre}}')">rm</a>
+      <a href="{{$pathPre}}/manifest"><b>{{.Name}}</b></a> <a href="{{$pathPre}}/initout">init out</a> <a href="#" onclick="apiPatch('{{$pathPre}}?status-failedtasks=0')">reset fails</a> [{{.Status.FailedTasks}} fails, {{.Status.HumanFriendly $maxFails}}, init v{{$pool.WorkerInitVersion .}}] <a href="#" onclick="apiDelete('{{$pathPre}}')">rm</a>
     {{- end}}
 {{end}}
+<b>Shared workers</b>
+{{- $maxFails := .Shared.AllowableWorkerFailures}}
+{{- range .Shared.WorkersCopy}}
+  {{- $pathPre := printf "/shared/workers/%s" .Name}}
+  <a href="{{$pathPre}}/manifest"><b>{{.Name}}</b></a> <a href="{{$pathPre}}/initout">init out</a> <a href="#" onclick="apiPatch('{{$pathPre}}?status-failedtasks=0')">reset fails</a> [{{.Status.FailedTasks}} fails, {{.Status.HumanFriendly $maxFails}}] <a href="#" onclick="apiDelete('{{$pathPre}}')">rm</a>
+{{- end}}
 </pre>
 <script>
 function apiDelete(url) {
@@ -57,9 +85,42 @@ function apiPatch(urlWithQS) {
 	}
 	xhr.send(null);
 }
+function apiPost(url) {
+	var xhr = new XMLHttpRequest();
+	xhr.open('POST', url, true);
+	xhr.onload = function() {
+		location.reload();
+	}
+	xhr.send(null);
+}
+function apiPause(url) {
+	var reason = prompt('Reason for pausing');
+	if (reason === null) {
+		return;
+	}
+	var xhr = new XMLHttpRequest();
+	xhr.open('POST', url, true);
+	xhr.onload = function() {
+		location.reload();
+	}
+	xhr.send(JSON.stringify({reason: reason}));
+}
+function apiBulk(url, selector, action) {
+	if (!confirm('Apply ' + action + ' to all matching?')) {
+		return;
+	}
+	var xhr = new XMLHttpRequest();
+	xhr.open('POST', url, true);
+	xhr.onload = function() {
+		location.reload();
+	}
+	xhr.send(JSON.stringify({selector: selector, action: action}));
+}
 </script>
 </body>
 </head>
 `
 
-var rootTempl = template.Must(template.New("root").Parse(rootTemplStr))
+var rootTempl = template.Must(template.New("root").Funcs(template.FuncMap{
+	"redact": bernie.RedactEnv,
+}).Parse(rootTemplStr))
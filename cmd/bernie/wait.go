@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/uluyol/bernie"
+)
+
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 5 * time.Minute
+	waitPollInterval   = 250 * time.Millisecond
+)
+
+type waitReq struct {
+	// Names are tasks to wait for.
+	Names []string `json:"names"`
+	// Selector picks more tasks to wait for, as in bulk operations.
+	Selector *selector `json:"selector"`
+	// Timeout is how many seconds to wait before replying
+	// even though some tasks have not finished.
+	Timeout float64 `json:"timeout"`
+}
+
+type waitResp struct {
+	// Done is set if every task has finished.
+	Done  bool         `json:"done"`
+	Tasks []waitResult `json:"tasks"`
+}
+
+type waitResult struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Err      string `json:"err,omitempty"`
+	ExitCode int    `json:"exitcode"`
+}
+
+// isFinalState reports whether a task in state will not run again
+// unless it is rerun or its tries are reset.
+func isFinalState(state string) bool {
+	return state == "succeeded" || state == "failed" || state == "killed"
+}
+
+// Possible paths:
+// /tasks/{group}/wait
+func (s *handler) tasksWaitHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	var reqData waitReq
+	if !s.decodeBodyInto(w, r, &reqData) {
+		return
+	}
+	if len(reqData.Names) == 0 && reqData.Selector == nil {
+		s.fail(w, r, codeInvalid, "need names or a selector")
+		return
+	}
+	if reqData.Selector != nil {
+		if err := reqData.Selector.validate(true); err != nil {
+			s.fail(w, r, codeInvalid, err.Error())
+			return
+		}
+	}
+	timeout := defaultWaitTimeout
+	if reqData.Timeout > 0 {
+		timeout = time.Duration(reqData.Timeout * float64(time.Second))
+	}
+	if timeout > maxWaitTimeout {
+		timeout = maxWaitTimeout
+	}
+	p := s.bernie.pool(group)
+	if p == nil {
+		s.fail(w, r, codeNotFound, errGroupNotExist.Error())
+		return
+	}
+
+	ts, missing := waitedTasks(s.bernie.Tasks(group), reqData, p.AllowableTaskTries())
+	switch {
+	case len(missing) > 0:
+		s.failDetails(w, r, codeNotFound, "unknown tasks: "+strings.Join(missing, ", "),
+			map[string][]string{"tasks": missing})
+		return
+	case len(ts) == 0:
+		s.fail(w, r, codeNotFound, "no tasks match")
+		return
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	tick := time.NewTicker(waitPollInterval)
+	defer tick.Stop()
+	results, done := waitResults(ts, p.AllowableTaskTries())
+wait:
+	for !done {
+		select {
+		case <-tick.C:
+			results, done = waitResults(ts, p.AllowableTaskTries())
+		case <-deadline.C:
+			break wait
+		case <-r.Context().Done():
+			return
+		}
+	}
+	s.reply(w, r, http.StatusOK, waitResp{done, results})
+}
+
+// waitedTasks returns the tasks among ts that req waits for
+// and the names in req that are not in ts.
+func waitedTasks(ts []*bernie.Task, req waitReq, maxTries int) (waited []*bernie.Task, missing []string) {
+	picked := make(map[*bernie.Task]bool)
+	for _, name := range req.Names {
+		t, ok := getTask(ts, name)
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		picked[t] = true
+	}
+	for _, t := range ts {
+		if picked[t] || (req.Selector != nil && req.Selector.matchesTask(t, maxTries)) {
+			waited = append(waited, t)
+		}
+	}
+	return waited, missing
+}
+
+// waitResults describes ts and reports whether they have all finished.
+func waitResults(ts []*bernie.Task, maxTries int) ([]waitResult, bool) {
+	results := make([]waitResult, len(ts))
+	done := true
+	for i, t := range ts {
+		st := t.Status()
+		results[i] = waitResult{
+			Name:     t.Name,
+			State:    taskState(st, maxTries),
+			ExitCode: st.ExitCode(),
+		}
+		if st.Err != nil {
+			results[i].Err = st.Err.Error()
+		}
+		done = done && isFinalState(results[i].State)
+	}
+	return results, done
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/uluyol/bernie"
+)
+
+const (
+	// deliveryTries is how many times a payload is sent before giving up.
+	deliveryTries = 5
+	// deliveryBackoff is the wait before the first retry. It doubles after each one.
+	deliveryBackoff = time.Second
+	// maxDeliveries is how many deliveries are kept per webhook.
+	maxDeliveries = 20
+	// maxQueued is how many payloads can wait to be sent to a webhook.
+	// The oldest is dropped to make room for a new one.
+	maxQueued = 100
+	// outputTailLines is how many lines of a task's output go in a payload.
+	outputTailLines = 20
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhook is a URL that is sent the events of a group.
+type webhook struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+	// Events filters the events sent to the webhook.
+	// If empty, every event is sent.
+	Events []bernie.EventKind `json:"events"`
+
+	mu         sync.Mutex
+	deliveries []*delivery // oldest first
+	queue      []queuedPayload
+	dropped    int // payloads dropped from a full queue
+	sending    bool
+}
+
+type queuedPayload struct {
+	d    *delivery
+	body []byte
+}
+
+// delivery records the sending of one event to a webhook.
+type delivery struct {
+	Event     bernie.EventKind `json:"event"`
+	Task      string           `json:"task,omitempty"`
+	Time      time.Time        `json:"time"`
+	Tries     int              `json:"tries"`
+	Status    int              `json:"status,omitempty"` // HTTP status of the last try
+	Err       string           `json:"err,omitempty"`
+	Delivered bool             `json:"delivered"`
+}
+
+func (h *webhook) wants(kind bernie.EventKind) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, k := range h.Events {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Deliveries returns copies of the recent deliveries to h, oldest first.
+func (h *webhook) Deliveries() []delivery {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ds := make([]delivery, len(h.deliveries))
+	for i, d := range h.deliveries {
+		ds[i] = *d
+	}
+	return ds
+}
+
+// LastDelivery returns a copy of the latest delivery to h, or nil if there is none.
+func (h *webhook) LastDelivery() *delivery {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.deliveries) == 0 {
+		return nil
+	}
+	d := *h.deliveries[len(h.deliveries)-1]
+	return &d
+}
+
+// Dropped returns how many payloads were dropped because too many were queued for h.
+func (h *webhook) Dropped() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.dropped
+}
+
+// record adds d to the deliveries of h, forgetting the oldest if needed.
+func (h *webhook) record(d *delivery) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.deliveries) >= maxDeliveries {
+		h.deliveries = append(h.deliveries[:0], h.deliveries[1:]...)
+	}
+	h.deliveries = append(h.deliveries, d)
+}
+
+// enqueue records d and queues body to be sent to h.
+// Payloads are sent one at a time in the order they were queued.
+// If maxQueued payloads are waiting, the oldest is dropped.
+func (h *webhook) enqueue(log logrus.FieldLogger, d *delivery, body []byte) {
+	h.record(d)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.queue) >= maxQueued {
+		old := h.queue[0].d
+		old.Err = "dropped: too many payloads queued"
+		h.queue = append(h.queue[:0], h.queue[1:]...)
+		h.dropped++
+		log.WithFields(logrus.Fields{
+			"url":   h.URL,
+			"event": old.Event,
+		}).Warn("dropping webhook payload")
+	}
+	h.queue = append(h.queue, queuedPayload{d, body})
+	if !h.sending {
+		h.sending = true
+		go h.sendQueued(log)
+	}
+}
+
+func (h *webhook) sendQueued(log logrus.FieldLogger) {
+	for {
+		h.mu.Lock()
+		if len(h.queue) == 0 {
+			h.sending = false
+			h.mu.Unlock()
+			return
+		}
+		q := h.queue[0]
+		h.queue = h.queue[1:]
+		h.mu.Unlock()
+		h.deliver(log, q.d, q.body)
+	}
+}
+
+// deliver sends body to h, retrying with backoff until it succeeds
+// or deliveryTries is reached.
+func (h *webhook) deliver(log logrus.FieldLogger, d *delivery, body []byte) {
+	wait := deliveryBackoff
+	for {
+		status, err := postWebhook(h.URL, body)
+		h.mu.Lock()
+		d.Tries++
+		d.Status = status
+		d.Err = ""
+		if err != nil {
+			d.Err = err.Error()
+		}
+		d.Delivered = err == nil
+		tries := d.Tries
+		h.mu.Unlock()
+		if err == nil {
+			return
+		}
+		if tries >= deliveryTries {
+			log.WithFields(logrus.Fields{
+				"url":   h.URL,
+				"event": d.Event,
+				"err":   err,
+			}).Error("giving up on webhook")
+			return
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func postWebhook(u string, body []byte) (int, error) {
+	resp, err := webhookClient.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("got status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookSet holds the webhooks of a group.
+type webhookSet struct {
+	mu     sync.Mutex
+	nextID int
+	hooks  []*webhook
+}
+
+func (s *webhookSet) add(u string, events []bernie.EventKind) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	s.hooks = append(s.hooks, &webhook{ID: s.nextID, URL: u, Events: events})
+	return s.nextID
+}
+
+func (s *webhookSet) remove(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, h := range s.hooks {
+		if h.ID == id {
+			s.hooks = append(s.hooks[:i], s.hooks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *webhookSet) Copy() []*webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*webhook(nil), s.hooks...)
+}
+
+// webhookPayload is the JSON body sent to webhooks.
+type webhookPayload struct {
+	// Text summarizes the event for chat services.
+	Text  string           `json:"text"`
+	Group string           `json:"group"`
+	Event bernie.EventKind `json:"event"`
+	Time  time.Time        `json:"time"`
+	// Task is set for task events.
+	Task *webhookTask `json:"task,omitempty"`
+	// Tasks counts the group's tasks by state for queue-empty events.
+	Tasks map[string]int `json:"tasks,omitempty"`
+}
+
+type webhookTask struct {
+	Name    string `json:"name"`
+	Attempt int    `json:"attempt"`
+	Worker  string `json:"worker"`
+	// Exit is empty if the attempt succeeded.
+	Exit string `json:"exit,omitempty"`
+	// Code is the exit code of the command, or -1 if it did not exit.
+	Code int `json:"code"`
+	// Output holds the last lines of the task's output.
+	Output string `json:"output"`
+}
+
+func newWebhookTask(t *bernie.Task, st bernie.TaskStatus) *webhookTask {
+	wt := &webhookTask{Name: t.Name, Attempt: len(st.Attempts), Code: st.ExitCode()}
+	if a := st.LastAttempt(); a != nil {
+		wt.Worker = a.Worker
+	}
+	if st.Err != nil {
+		wt.Exit = st.Err.Error()
+	}
+	if out, err := st.GetOutput(); err == nil {
+		wt.Output = tail(out, outputTailLines)
+	}
+	return wt
+}
+
+// tail returns the last n lines of the output out,
+// ignoring the blank lines and notice that tmux adds after it.
+func tail(out string, n int) string {
+	out = strings.TrimRight(out, " \n")
+	if i := strings.LastIndexByte(out, '\n'); strings.HasPrefix(out[i+1:], "Pane is dead") {
+		out = strings.TrimRight(out[:i+1], " \n")
+	}
+	lines := strings.Split(out, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// notify queues ev to be sent to the webhooks of g that want it.
+func (s *bernieServer) notify(g *Group, ev bernie.Event) {
+	var hooks []*webhook
+	for _, h := range g.Webhooks.Copy() {
+		if h.wants(ev.Kind) {
+			hooks = append(hooks, h)
+		}
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	p := webhookPayload{
+		Group: g.Name,
+		Event: ev.Kind,
+		Time:  ev.Time,
+	}
+	d := delivery{Event: ev.Kind, Time: ev.Time}
+	if ev.Kind == bernie.QueueEmpty {
+		maxTries := g.Pool.AllowableTaskTries()
+		p.Tasks = make(map[string]int)
+		for _, t := range s.Tasks(g.Name) {
+			p.Tasks[taskState(t.Status(), maxTries)]++
+		}
+		p.Text = fmt.Sprintf("group %s has no tasks left: %d succeeded, %d failed, %d killed",
+			g.Name, p.Tasks["succeeded"], p.Tasks["failed"], p.Tasks["killed"])
+	} else {
+		p.Task = newWebhookTask(ev.Task, ev.Status)
+		d.Task = ev.Task.Name
+		p.Text = fmt.Sprintf("task %s in group %s %s", ev.Task.Name, g.Name,
+			strings.TrimPrefix(string(ev.Kind), "task-"))
+		if p.Task.Exit != "" {
+			p.Text += ": " + p.Task.Exit
+		}
+	}
+	body, err := json.Marshal(&p)
+	if err != nil {
+		s.log.WithField("err", err).Error("unable to encode webhook payload")
+		return
+	}
+	for _, h := range hooks {
+		d := d
+		h.enqueue(s.log.WithField("group", g.Name), &d, body)
+	}
+}
+
+func (s *bernieServer) webhooks(group string) *webhookSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if g, ok := s.groups[group]; ok {
+		return g.Webhooks
+	}
+	return nil
+}
+
+type webhookReq struct {
+	URL    string             `json:"url"`
+	Events []bernie.EventKind `json:"events"`
+}
+
+func (r *webhookReq) validate() error {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return fmt.Errorf("bad url: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("bad url %q: need an http or https url", r.URL)
+	}
+	for _, k := range r.Events {
+		known := false
+		for _, kk := range bernie.EventKinds {
+			known = known || k == kk
+		}
+		if !known {
+			return fmt.Errorf("unknown event %q", k)
+		}
+	}
+	return nil
+}
+
+type webhooksAddResp struct {
+	ID int `json:"id"`
+}
+
+// Possible paths:
+// /groups/{group}/webhooks
+func (s *handler) webhooksAddHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	var reqData webhookReq
+	if !s.decodeBodyInto(w, r, &reqData) {
+		return
+	}
+	if err := reqData.validate(); err != nil {
+		s.fail(w, r, codeInvalid, err.Error())
+		return
+	}
+	hooks := s.bernie.webhooks(group)
+	if hooks == nil {
+		s.fail(w, r, codeNotFound, errGroupNotExist.Error())
+		return
+	}
+	id := hooks.add(reqData.URL, reqData.Events)
+	s.log.WithFields(logrus.Fields{
+		"group": group,
+		"id":    id,
+		"url":   reqData.URL,
+	}).Info("added webhook")
+	s.reply(w, r, http.StatusCreated, webhooksAddResp{id})
+}
+
+type webhookView struct {
+	ID         int                `json:"id"`
+	URL        string             `json:"url"`
+	Events     []bernie.EventKind `json:"events"`
+	Deliveries []delivery         `json:"deliveries"`
+	// Dropped counts the payloads dropped because too many were queued.
+	Dropped int `json:"dropped"`
+}
+
+type webhooksListResp struct {
+	Webhooks []webhookView `json:"webhooks"`
+}
+
+// Possible paths:
+// /groups/{group}/webhooks
+func (s *handler) webhooksListHandler(w http.ResponseWriter, r *http.Request) {
+	hooks := s.bernie.webhooks(mux.Vars(r)["group"])
+	if hooks == nil {
+		s.fail(w, r, codeNotFound, errGroupNotExist.Error())
+		return
+	}
+	resp := webhooksListResp{Webhooks: []webhookView{}}
+	for _, h := range hooks.Copy() {
+		resp.Webhooks = append(resp.Webhooks, webhookView{
+			ID:         h.ID,
+			URL:        h.URL,
+			Events:     h.Events,
+			Deliveries: h.Deliveries(),
+			Dropped:    h.Dropped(),
+		})
+	}
+	s.reply(w, r, http.StatusOK, &resp)
+}
+
+// Possible paths:
+// /groups/{group}/webhooks/{id}
+func (s *handler) webhooksDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	hooks := s.bernie.webhooks(vars["group"])
+	if err != nil || hooks == nil || !hooks.remove(id) {
+		s.fail(w, r, codeNotFound, "unknown group or webhook")
+		return
+	}
+	s.reply(w, r, http.StatusNoContent, nil)
+}
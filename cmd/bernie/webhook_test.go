@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/uluyol/bernie"
+)
+
+// hookServer is a stand-in for a webhook receiver.
+// It fails the first failures requests with a 503.
+type hookServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	failures int
+	times    []time.Time
+	bodies   []webhookPayload
+}
+
+func newHookServer(t *testing.T, failures int) *hookServer {
+	s := &hookServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("bad payload: %v", err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.times = append(s.times, time.Now())
+		s.bodies = append(s.bodies, p)
+		if len(s.times) <= s.failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	return s
+}
+
+func (s *hookServer) received() ([]time.Time, []webhookPayload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Time(nil), s.times...), append([]webhookPayload(nil), s.bodies...)
+}
+
+func discardLogger() *logrus.Logger {
+	l := logrus.New()
+	l.Out = ioutil.Discard
+	return l
+}
+
+// waitDelivered waits for the last delivery to h to be delivered.
+func waitDelivered(t *testing.T, h *webhook) delivery {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if d := h.LastDelivery(); d != nil && d.Delivered {
+			return *d
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("webhook %s was not delivered, last delivery: %+v", h.URL, h.LastDelivery())
+	return delivery{}
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	srv := newHookServer(t, 0)
+	defer srv.Close()
+
+	h := &webhook{URL: srv.URL}
+	body, err := json.Marshal(&webhookPayload{Group: "g", Event: bernie.TaskFailed, Text: "task a in group g failed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.enqueue(discardLogger(), &delivery{Event: bernie.TaskFailed, Task: "a"}, body)
+
+	d := waitDelivered(t, h)
+	if d.Tries != 1 || d.Status != http.StatusOK || d.Err != "" {
+		t.Errorf("delivery = %+v, want one try with status 200", d)
+	}
+	_, got := srv.received()
+	if len(got) != 1 || got[0].Group != "g" || got[0].Event != bernie.TaskFailed {
+		t.Errorf("received %+v, want one task-failed payload for group g", got)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	srv := newHookServer(t, 1)
+	defer srv.Close()
+
+	h := &webhook{URL: srv.URL}
+	h.enqueue(discardLogger(), &delivery{Event: bernie.QueueEmpty}, []byte(`{}`))
+
+	d := waitDelivered(t, h)
+	if d.Tries != 2 || d.Status != http.StatusOK || d.Err != "" {
+		t.Errorf("delivery = %+v, want two tries ending with status 200", d)
+	}
+	times, _ := srv.received()
+	if len(times) != 2 {
+		t.Fatalf("received %d requests, want 2", len(times))
+	}
+	if waited := times[1].Sub(times[0]); waited < deliveryBackoff {
+		t.Errorf("retried after %v, want at least %v", waited, deliveryBackoff)
+	}
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	h := &webhook{URL: srv.URL}
+	log := discardLogger()
+	h.enqueue(log, &delivery{Event: bernie.TaskFailed, Task: "t0"}, []byte(`{}`))
+	select {
+	case <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatal("first payload was not sent")
+	}
+
+	// The first payload is being sent, so these are queued.
+	for i := 1; i <= maxQueued+2; i++ {
+		h.enqueue(log, &delivery{Event: bernie.TaskFailed, Task: fmt.Sprintf("t%d", i)}, []byte(`{}`))
+	}
+	if n := h.Dropped(); n != 2 {
+		t.Errorf("dropped %d payloads, want 2", n)
+	}
+	h.mu.Lock()
+	n, first := len(h.queue), h.queue[0].d.Task
+	h.mu.Unlock()
+	if n != maxQueued || first != "t3" {
+		t.Errorf("queue has %d payloads starting with %s, want %d starting with t3", n, first, maxQueued)
+	}
+}
+
+func TestWebhookWants(t *testing.T) {
+	tests := []struct {
+		events []bernie.EventKind
+		kind   bernie.EventKind
+		want   bool
+	}{
+		{events: nil, kind: bernie.TaskSucceeded, want: true},
+		{events: nil, kind: bernie.QueueEmpty, want: true},
+		{events: []bernie.EventKind{bernie.TaskFailed}, kind: bernie.TaskFailed, want: true},
+		{events: []bernie.EventKind{bernie.TaskFailed}, kind: bernie.TaskRetrying, want: false},
+		{events: []bernie.EventKind{bernie.TaskFailed, bernie.QueueEmpty}, kind: bernie.QueueEmpty, want: true},
+	}
+	for _, test := range tests {
+		h := &webhook{Events: test.events}
+		if got := h.wants(test.kind); got != test.want {
+			t.Errorf("webhook with events %v wants(%s) = %t, want %t", test.events, test.kind, got, test.want)
+		}
+	}
+}
+
+func TestNotifyFilters(t *testing.T) {
+	all := newHookServer(t, 0)
+	defer all.Close()
+	empty := newHookServer(t, 0)
+	defer empty.Close()
+	failed := newHookServer(t, 0)
+	defer failed.Close()
+
+	log := discardLogger()
+	s := &bernieServer{log: log}
+	g := &Group{
+		Name:     "g",
+		Pool:     bernie.NewWorkerPool(log, 3, 3, nil),
+		Webhooks: &webhookSet{},
+	}
+	s.groups = map[string]*Group{g.Name: g}
+	g.Webhooks.add(all.URL, nil)
+	g.Webhooks.add(empty.URL, []bernie.EventKind{bernie.QueueEmpty})
+	g.Webhooks.add(failed.URL, []bernie.EventKind{bernie.TaskFailed})
+
+	s.notify(g, bernie.Event{Kind: bernie.QueueEmpty, Time: time.Now()})
+
+	hooks := g.Webhooks.Copy()
+	for _, h := range hooks[:2] {
+		waitDelivered(t, h)
+	}
+	for _, srv := range []*hookServer{all, empty} {
+		_, got := srv.received()
+		if len(got) != 1 || got[0].Event != bernie.QueueEmpty || got[0].Group != "g" {
+			t.Errorf("%s received %+v, want one queue-empty payload for group g", srv.URL, got)
+		}
+	}
+	if ds := hooks[2].Deliveries(); len(ds) != 0 {
+		t.Errorf("webhook for task-failed has deliveries %+v, want none", ds)
+	}
+	if _, got := failed.received(); len(got) != 0 {
+		t.Errorf("webhook for task-failed received %+v, want nothing", got)
+	}
+}
@@ -0,0 +1,163 @@
+package bernie
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SetEnv sets the default environment, as KEY=VALUE entries,
+// of the tasks and init task run by p.
+//
+// Tasks are started with the server's environment,
+// overridden by the variables of the worker's manifest,
+// overridden by the pool's environment, overridden by the task's Env,
+// overridden by the pool's secrets.
+func (p *WorkerPool) SetEnv(env []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.env = append([]string(nil), env...)
+}
+
+func (p *WorkerPool) Env() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.env...)
+}
+
+// SetSecrets adds the variables in secrets to p's secrets.
+// Variables with an empty value are removed instead.
+// Secrets are added to the environment of tasks when they start
+// but are not part of any task's definition.
+func (p *WorkerPool) SetSecrets(secrets map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.secrets == nil {
+		p.secrets = make(map[string]string)
+	}
+	for k, v := range secrets {
+		if v == "" {
+			delete(p.secrets, k)
+		} else {
+			p.secrets[k] = v
+		}
+	}
+}
+
+// SecretKeys returns the sorted names of p's secret variables.
+func (p *WorkerPool) SecretKeys() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make([]string, 0, len(p.secrets))
+	for k := range p.secrets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// applyDefaults gives t the pool-wide settings it uses when it runs.
+//
+// Make sure that p.mu is held before calling this method!
+func (p *WorkerPool) applyDefaults(t *Task) {
+	secrets := make([]string, 0, len(p.secrets))
+	for k, v := range p.secrets {
+		secrets = append(secrets, k+"="+v)
+	}
+	sort.Strings(secrets)
+
+	t.mu.Lock()
+	t.defaults = taskDefaults{
+		exit:    p.exitPolicy,
+		env:     p.env,
+		secrets: secrets,
+	}
+	t.mu.Unlock()
+}
+
+// freshInit returns a copy of p's init task that is ready to run.
+func (p *WorkerPool) freshInit() *Task {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.initWithDefaults()
+}
+
+// initWithDefaults is like freshInit.
+//
+// Make sure that p.mu is held before calling this method!
+func (p *WorkerPool) initWithDefaults() *Task {
+	t := p.initTask.FreshCopy()
+	p.applyDefaults(t)
+	return t
+}
+
+// taskDefaults are the settings a task gets from its pool.
+type taskDefaults struct {
+	exit    *ExitPolicy
+	env     []string
+	secrets []string
+}
+
+// definedEnv returns the environment given to t by its pool and its Env,
+// excluding secrets.
+func (t *Task) definedEnv() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append(append([]string(nil), t.defaults.env...), t.Env...)
+}
+
+// launchEnv returns the full environment of an attempt of t that uses wdir
+// on a worker whose manifest exports manifestEnv.
+func (t *Task) launchEnv(wdir string, manifestEnv []string) []string {
+	env := append(os.Environ(), "WORKER_MANIFEST="+filepath.Join(wdir, "wmanifest"))
+	env = append(env, manifestEnv...)
+	env = append(env, t.definedEnv()...)
+	t.mu.Lock()
+	env = append(env, t.defaults.secrets...)
+	t.mu.Unlock()
+	return env
+}
+
+// exportScript returns a shell script that exports env.
+// Entries that are not valid shell variables are skipped.
+func exportScript(env []string) []byte {
+	var buf bytes.Buffer
+	for _, kv := range env {
+		kv := strings.SplitN(kv, "=", 2)
+		if len(kv) != 2 || !isShellName(kv[0]) {
+			continue
+		}
+		buf.WriteString("export " + kv[0] + "='" + strings.Replace(kv[1], "'", `'\''`, -1) + "'\n")
+	}
+	return buf.Bytes()
+}
+
+func isShellName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if c != '_' && !unicode.IsLetter(c) && !(i > 0 && unicode.IsDigit(c)) || c > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+// RedactEnv replaces the values of entries in env whose key is in secretKeys.
+func RedactEnv(env []string, secretKeys []string) []string {
+	out := make([]string, len(env))
+	for i, kv := range env {
+		out[i] = kv
+		for _, k := range secretKeys {
+			if strings.HasPrefix(kv, k+"=") {
+				out[i] = k + "=<redacted>"
+				break
+			}
+		}
+	}
+	return out
+}
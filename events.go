@@ -0,0 +1,83 @@
+package bernie
+
+import "time"
+
+// EventKind names a change in a pool that can be reported to an event handler.
+type EventKind string
+
+const (
+	// TaskSucceeded is sent when an attempt of a task succeeds.
+	TaskSucceeded EventKind = "task-succeeded"
+	// TaskRetrying is sent when an attempt of a task fails and
+	// the task is queued to be tried again.
+	TaskRetrying EventKind = "task-retrying"
+	// TaskFailed is sent when an attempt of a task fails and
+	// the task will not be tried again.
+	// Tasks that are killed do not send it.
+	TaskFailed EventKind = "task-failed"
+	// QueueEmpty is sent when a task finishes and the pool has
+	// no other tasks queued or running.
+	QueueEmpty EventKind = "queue-empty"
+)
+
+// EventKinds lists every EventKind.
+var EventKinds = []EventKind{TaskSucceeded, TaskRetrying, TaskFailed, QueueEmpty}
+
+// Event is a change in a pool.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+	// Task is the task whose attempt finished.
+	// For QueueEmpty, it is the last task to finish.
+	Task *Task
+	// Status is the status of Task when its attempt finished.
+	Status TaskStatus
+}
+
+// SetEventHandler makes p call fn for each event.
+// fn is called without any of p's locks held, but it blocks
+// the goroutine that finished the task, so it should return quickly.
+// Task events are sent before a retried task's session is killed,
+// so the output of the attempt can still be read.
+// If fn is nil, events are dropped.
+func (p *WorkerPool) SetEventHandler(fn func(Event)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onEvent = fn
+}
+
+// SetInitHandler makes p call fn with w and the attempt each time a worker w
+// runs p's init task, including shared workers that run it to serve p.
+// Like the event handler, fn is called without any of p's locks held.
+// If fn is nil, init attempts are not reported.
+func (p *WorkerPool) SetInitHandler(fn func(w *Worker, a Attempt)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onInit = fn
+}
+
+// initRan reports the last attempt of the init task t, which w just ran,
+// to p's init handler.
+func (p *WorkerPool) initRan(w *Worker, t *Task) {
+	p.mu.Lock()
+	onInit := p.onInit
+	p.mu.Unlock()
+	if a := t.Status().LastAttempt(); onInit != nil && a != nil {
+		onInit(w, *a)
+	}
+}
+
+// queueEmpty reports whether p has no tasks queued or running.
+//
+// Make sure that p.mu is held before calling this method!
+func (p *WorkerPool) queueEmpty() bool {
+	if len(p.running) > 0 {
+		return false
+	}
+	for n := p.queued.next; n != &p.queued; n = n.next {
+		if !p.stale(n) {
+			return false
+		}
+	}
+	return true
+}
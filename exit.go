@@ -0,0 +1,171 @@
+package bernie
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ExitPolicy classifies how a task's command exited.
+//
+// Each list holds exit codes ("3") or signal names ("SIGSEGV").
+// Exits in Success count as success; by default only 0 does.
+// Exits in Permanent fail the task without retrying it.
+// Other failures are retried, unless Retryable is not empty,
+// in which case only the exits it lists are retried.
+type ExitPolicy struct {
+	Success   ExitList `json:"success"`
+	Retryable ExitList `json:"retryable"`
+	Permanent ExitList `json:"permanent"`
+}
+
+// ExitList is a list of exit codes and signal names.
+// In JSON, exit codes can be numbers or strings,
+// so [0, "03", "SIGSEGV"] is the same as ["0", "3", "SIGSEGV"].
+type ExitList []string
+
+func (l *ExitList) UnmarshalJSON(b []byte) error {
+	var entries []json.RawMessage
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+	if entries == nil {
+		*l = nil
+		return nil
+	}
+	list := make(ExitList, len(entries))
+	for i, e := range entries {
+		var code int
+		if json.Unmarshal(e, &code) == nil {
+			list[i] = strconv.Itoa(code)
+			continue
+		}
+		if err := json.Unmarshal(e, &list[i]); err != nil {
+			return fmt.Errorf("exit list entry %s is neither an exit code nor a string", e)
+		}
+		if code, err := strconv.Atoi(list[i]); err == nil {
+			list[i] = strconv.Itoa(code)
+		}
+	}
+	*l = list
+	return nil
+}
+
+// Validate checks that every entry of the policy is an exit code or signal name.
+func (p *ExitPolicy) Validate() error {
+	for _, l := range [][]string{p.Success, p.Retryable, p.Permanent} {
+		for _, e := range l {
+			if _, err := strconv.Atoi(e); err == nil {
+				continue
+			}
+			if _, ok := signalNumber(e); !ok {
+				return fmt.Errorf("%q is neither an exit code nor a signal", e)
+			}
+		}
+	}
+	return nil
+}
+
+// ExitError is the error of an attempt whose command did not exit successfully.
+type ExitError struct {
+	Code int
+	// Signal is the name of the signal that killed the command, if any.
+	Signal    string
+	Permanent bool
+}
+
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("exit status %d", e.Code)
+	if e.Signal != "" {
+		msg = "killed by " + e.Signal
+	}
+	if e.Permanent {
+		msg += " (permanent)"
+	}
+	return msg
+}
+
+// IsPermanent reports whether err is a failure that should not be retried.
+func IsPermanent(err error) bool {
+	if err == ErrNoWorkerLeft {
+		return true
+	}
+	e, ok := err.(*ExitError)
+	return ok && e.Permanent
+}
+
+// exitSignal returns the name of the signal that killed a command
+// that exited with the shell status code, or "" if it was not killed by one.
+func exitSignal(code int) string {
+	if code > 128 {
+		return signalNames[code-128]
+	}
+	return ""
+}
+
+// classify returns the error for a command that exited with the shell
+// status code, or nil if the exit counts as success.
+func (p *ExitPolicy) classify(code int) error {
+	e := &ExitError{Code: code, Signal: exitSignal(code)}
+	matches := func(l []string) bool {
+		for _, x := range l {
+			if n, err := strconv.Atoi(x); err == nil {
+				if n == code {
+					return true
+				}
+				continue
+			}
+			if e.Signal != "" && normalizeSignal(x) == e.Signal {
+				return true
+			}
+		}
+		return false
+	}
+
+	if p == nil {
+		if code == 0 {
+			return nil
+		}
+		return e
+	}
+	if matches(p.Success) || (code == 0 && len(p.Success) == 0) {
+		return nil
+	}
+	switch {
+	case matches(p.Permanent):
+		e.Permanent = true
+	case matches(p.Retryable):
+	default:
+		e.Permanent = len(p.Retryable) > 0
+	}
+	return e
+}
+
+var signalNames = map[int]string{
+	1: "SIGHUP", 2: "SIGINT", 3: "SIGQUIT", 4: "SIGILL", 5: "SIGTRAP",
+	6: "SIGABRT", 7: "SIGBUS", 8: "SIGFPE", 9: "SIGKILL", 10: "SIGUSR1",
+	11: "SIGSEGV", 12: "SIGUSR2", 13: "SIGPIPE", 14: "SIGALRM", 15: "SIGTERM",
+	16: "SIGSTKFLT", 17: "SIGCHLD", 18: "SIGCONT", 19: "SIGSTOP", 20: "SIGTSTP",
+	21: "SIGTTIN", 22: "SIGTTOU", 23: "SIGURG", 24: "SIGXCPU", 25: "SIGXFSZ",
+	26: "SIGVTALRM", 27: "SIGPROF", 28: "SIGWINCH", 29: "SIGIO", 30: "SIGPWR",
+	31: "SIGSYS",
+}
+
+func normalizeSignal(name string) string {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	return name
+}
+
+func signalNumber(name string) (int, bool) {
+	name = normalizeSignal(name)
+	for n, s := range signalNames {
+		if s == name {
+			return n, true
+		}
+	}
+	return 0, false
+}
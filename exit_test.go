@@ -0,0 +1,104 @@
+package bernie
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestExitPolicyClassify(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    *ExitPolicy
+		code      int
+		ok        bool
+		permanent bool
+		signal    string
+	}{
+		{name: "no policy, success", policy: nil, code: 0, ok: true},
+		{name: "no policy, failure", policy: nil, code: 1},
+		{name: "no policy, signal", policy: nil, code: 128 + 9, signal: "SIGKILL"},
+		{name: "empty policy, success", policy: &ExitPolicy{}, code: 0, ok: true},
+		{name: "empty policy, failure", policy: &ExitPolicy{}, code: 2},
+		{name: "listed success", policy: &ExitPolicy{Success: ExitList{"0", "3"}}, code: 3, ok: true},
+		{name: "zero not listed", policy: &ExitPolicy{Success: ExitList{"3"}}, code: 0},
+		{name: "permanent code", policy: &ExitPolicy{Permanent: ExitList{"2"}}, code: 2, permanent: true},
+		{name: "permanent signal", policy: &ExitPolicy{Permanent: ExitList{"segv"}}, code: 128 + 11, permanent: true, signal: "SIGSEGV"},
+		{name: "retryable listed", policy: &ExitPolicy{Retryable: ExitList{"75"}}, code: 75},
+		{name: "retryable not listed", policy: &ExitPolicy{Retryable: ExitList{"75"}}, code: 1, permanent: true},
+		{name: "permanent wins over retryable", policy: &ExitPolicy{Retryable: ExitList{"1"}, Permanent: ExitList{"1"}}, code: 1, permanent: true},
+		{name: "leading zero", policy: &ExitPolicy{Permanent: ExitList{"03"}}, code: 3, permanent: true},
+		{name: "plus sign", policy: &ExitPolicy{Success: ExitList{"+3"}}, code: 3, ok: true},
+		{name: "success wins over permanent", policy: &ExitPolicy{Success: ExitList{"1"}, Permanent: ExitList{"1"}}, code: 1, ok: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.policy.classify(test.code)
+			if test.ok {
+				if err != nil {
+					t.Fatalf("classify(%d) = %v, want success", test.code, err)
+				}
+				return
+			}
+			e, ok := err.(*ExitError)
+			if !ok {
+				t.Fatalf("classify(%d) = %v, want an *ExitError", test.code, err)
+			}
+			if e.Code != test.code || e.Permanent != test.permanent || e.Signal != test.signal {
+				t.Errorf("classify(%d) = %+v, want code %d, permanent %t, signal %q",
+					test.code, e, test.code, test.permanent, test.signal)
+			}
+			if IsPermanent(err) != test.permanent {
+				t.Errorf("IsPermanent(%v) = %t, want %t", err, !test.permanent, test.permanent)
+			}
+		})
+	}
+}
+
+func TestExitListUnmarshal(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ExitList
+		wantErr bool
+	}{
+		{in: `[0, 3]`, want: ExitList{"0", "3"}},
+		{in: `["0", "3"]`, want: ExitList{"0", "3"}},
+		{in: `[1, "SIGSEGV"]`, want: ExitList{"1", "SIGSEGV"}},
+		{in: `["03", "+3", "-1"]`, want: ExitList{"3", "3", "-1"}},
+		{in: `[]`, want: ExitList{}},
+		{in: `null`, want: nil},
+		{in: `[1.5]`, wantErr: true},
+		{in: `[true]`, wantErr: true},
+		{in: `3`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		var got ExitList
+		err := json.Unmarshal([]byte(test.in), &got)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("unmarshal %s = %q, want an error", test.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unmarshal %s: %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unmarshal %s = %#v, want %#v", test.in, got, test.want)
+		}
+	}
+
+	var p ExitPolicy
+	if err := json.Unmarshal([]byte(`{"success": [0, 3], "permanent": ["SIGKILL", 2]}`), &p); err != nil {
+		t.Fatalf("unmarshal policy: %v", err)
+	}
+	if err := p.Validate(); err != nil {
+		t.Errorf("policy is invalid: %v", err)
+	}
+	if p.classify(3) != nil {
+		t.Errorf("exit 3 is not a success")
+	}
+}
@@ -0,0 +1,117 @@
+package bernie
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ManifestSchema describes structured worker manifests.
+//
+// A structured manifest is a JSON object.
+// The schema maps each required top-level key to its JSON type:
+// "string", "number", "bool", "object", or "array".
+// Keys not in the schema are allowed.
+type ManifestSchema map[string]string
+
+// Validate checks that s only uses known types.
+func (s ManifestSchema) Validate() error {
+	for k, typ := range s {
+		switch typ {
+		case "string", "number", "bool", "object", "array":
+		default:
+			return fmt.Errorf("key %q has unknown type %q", k, typ)
+		}
+	}
+	return nil
+}
+
+// Check validates manifest against s and returns the environment that
+// exposes its fields to tasks.
+// Each top-level key becomes BERNIE_MANIFEST_<KEY>, with the key upper-cased
+// and characters other than letters and digits replaced by underscores.
+// Strings are exported as is and other values as JSON.
+func (s ManifestSchema) Check(manifest string) ([]string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(manifest), &fields); err != nil {
+		return nil, fmt.Errorf("manifest is not a JSON object: %v", err)
+	}
+	if fields == nil {
+		return nil, fmt.Errorf("manifest is not a JSON object")
+	}
+	for k, typ := range s {
+		v, ok := fields[k]
+		if !ok {
+			return nil, fmt.Errorf("manifest is missing %q", k)
+		}
+		if got := jsonType(v); got != typ {
+			return nil, fmt.Errorf("manifest key %q is %s, want %s", k, got, typ)
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := fields[k]
+		var str string
+		if json.Unmarshal(v, &str) != nil {
+			var buf bytes.Buffer
+			json.Compact(&buf, v)
+			str = buf.String()
+		}
+		env = append(env, "BERNIE_MANIFEST_"+manifestEnvName(k)+"="+str)
+	}
+	return env, nil
+}
+
+func manifestEnvName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		case 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+func jsonType(v json.RawMessage) string {
+	v = bytes.TrimSpace(v)
+	if len(v) == 0 {
+		return "invalid"
+	}
+	switch v[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "bool"
+	case 'n':
+		return "null"
+	}
+	return "number"
+}
+
+// SetManifestEnv sets variables derived from w's manifest that are given
+// to every task w runs.
+func (w *Worker) SetManifestEnv(env []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.manifestEnv = env
+}
+
+func (w *Worker) ManifestEnv() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.manifestEnv
+}
@@ -0,0 +1,111 @@
+package bernie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestManifestSchemaCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   ManifestSchema
+		manifest string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "exports every key",
+			schema:   ManifestSchema{"host": "string"},
+			manifest: `{"host": "gpu-1", "gpus": 4, "extra": {"a": [1, 2]}}`,
+			want: []string{
+				`BERNIE_MANIFEST_EXTRA={"a":[1,2]}`,
+				"BERNIE_MANIFEST_GPUS=4",
+				"BERNIE_MANIFEST_HOST=gpu-1",
+			},
+		},
+		{
+			name:     "every type",
+			schema:   ManifestSchema{"s": "string", "n": "number", "b": "bool", "o": "object", "a": "array"},
+			manifest: `{"s": "x", "n": 1.5, "b": true, "o": {}, "a": []}`,
+			want: []string{
+				"BERNIE_MANIFEST_A=[]",
+				"BERNIE_MANIFEST_B=true",
+				"BERNIE_MANIFEST_N=1.5",
+				"BERNIE_MANIFEST_O={}",
+				"BERNIE_MANIFEST_S=x",
+			},
+		},
+		{
+			name:     "key names",
+			schema:   ManifestSchema{},
+			manifest: `{"data-dir": "/d", "n.gpus": 2}`,
+			want: []string{
+				"BERNIE_MANIFEST_DATA_DIR=/d",
+				"BERNIE_MANIFEST_N_GPUS=2",
+			},
+		},
+		{
+			name:     "missing key",
+			schema:   ManifestSchema{"host": "string"},
+			manifest: `{"gpus": 4}`,
+			wantErr:  true,
+		},
+		{
+			name:     "wrong type",
+			schema:   ManifestSchema{"gpus": "number"},
+			manifest: `{"gpus": "4"}`,
+			wantErr:  true,
+		},
+		{
+			name:     "null is not a string",
+			schema:   ManifestSchema{"host": "string"},
+			manifest: `{"host": null}`,
+			wantErr:  true,
+		},
+		{
+			name:     "not an object",
+			schema:   ManifestSchema{},
+			manifest: `["host"]`,
+			wantErr:  true,
+		},
+		{
+			name:     "null",
+			schema:   ManifestSchema{},
+			manifest: `null`,
+			wantErr:  true,
+		},
+		{
+			name:     "not JSON",
+			schema:   ManifestSchema{},
+			manifest: `gpu-1`,
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.schema.Check(test.manifest)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("Check(%s) = %q, want an error", test.manifest, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Check(%s): %v", test.manifest, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Check(%s) = %q, want %q", test.manifest, got, test.want)
+			}
+		})
+	}
+}
+
+func TestManifestSchemaValidate(t *testing.T) {
+	if err := (ManifestSchema{"a": "string", "b": "bool"}).Validate(); err != nil {
+		t.Errorf("valid schema: %v", err)
+	}
+	if err := (ManifestSchema{"a": "int"}).Validate(); err == nil {
+		t.Errorf("schema with type int is valid")
+	}
+}
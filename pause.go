@@ -0,0 +1,47 @@
+package bernie
+
+import "time"
+
+// PauseInfo describes why a pool was paused.
+type PauseInfo struct {
+	By     string    `json:"by"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// Pause stops p from starting queued tasks until Resume is called.
+// Running tasks are left alone, and tasks that fail are requeued as usual.
+func (p *WorkerPool) Pause(by, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = &PauseInfo{
+		By:     by,
+		Reason: reason,
+		Since:  time.Now(),
+	}
+	p.log.Infof("paused by %s: %s", by, reason)
+}
+
+// Resume lets p start queued tasks again.
+func (p *WorkerPool) Resume() {
+	p.mu.Lock()
+	p.paused = nil
+	p.log.Infof("resumed")
+	p.schedule()
+	shared := p.shared
+	p.mu.Unlock()
+	if shared != nil {
+		shared.schedule()
+	}
+}
+
+// Paused returns why p was paused, or nil if it is not paused.
+func (p *WorkerPool) Paused() *PauseInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused == nil {
+		return nil
+	}
+	info := *p.paused
+	return &info
+}
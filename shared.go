@@ -0,0 +1,327 @@
+package bernie
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SharedPool is a set of workers that serve several WorkerPools.
+//
+// Each free worker is given to the attached pool that has queued tasks and
+// the lowest recent usage relative to its weight.
+// Usage decays exponentially with the configured half-life.
+// A worker runs a pool's init task before it serves that pool,
+// unless the last init task it ran is the current one of that pool.
+type SharedPool struct {
+	log               Logger
+	maxWorkerFailures int
+	halfLife          time.Duration
+
+	mu       sync.Mutex
+	pool     []*Worker
+	free     []*Worker
+	members  []*shareMember
+	lastInit map[*Worker]*shareMember // whose init task each worker last ran
+}
+
+type shareMember struct {
+	pool   *WorkerPool
+	weight float64
+
+	usage   float64 // worker-seconds, decayed up to updated
+	updated time.Time
+	running map[*Task]time.Time
+
+	inited    map[*Worker]int
+	initFails map[*Worker]int
+}
+
+func NewSharedPool(log Logger, maxFailures int, halfLife time.Duration) *SharedPool {
+	return &SharedPool{
+		log:               log,
+		maxWorkerFailures: maxFailures,
+		halfLife:          halfLife,
+		lastInit:          make(map[*Worker]*shareMember),
+	}
+}
+
+func (s *SharedPool) AllowableWorkerFailures() int {
+	return s.maxWorkerFailures
+}
+
+// Attach makes the shared workers serve p.
+// weight is p's share of the workers relative to the other attached pools.
+func (s *SharedPool) Attach(p *WorkerPool, weight float64) {
+	s.mu.Lock()
+	s.members = append(s.members, &shareMember{
+		pool:      p,
+		weight:    weight,
+		updated:   time.Now(),
+		running:   make(map[*Task]time.Time),
+		inited:    make(map[*Worker]int),
+		initFails: make(map[*Worker]int),
+	})
+	s.mu.Unlock()
+
+	p.mu.Lock()
+	p.shared = s
+	p.share = weight
+	p.mu.Unlock()
+
+	s.schedule()
+}
+
+// Detach stops s from serving p.
+// Tasks of p that are running on shared workers are left alone.
+func (s *SharedPool) Detach(p *WorkerPool) {
+	s.mu.Lock()
+	for i, m := range s.members {
+		if m.pool == p {
+			s.members = append(s.members[:i], s.members[i+1:]...)
+			for w, last := range s.lastInit {
+				if last == m {
+					delete(s.lastInit, w)
+				}
+			}
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	p.mu.Lock()
+	if p.shared == s {
+		p.shared = nil
+	}
+	p.share = 0
+	p.mu.Unlock()
+}
+
+// Usage returns the decayed number of worker-seconds that p has recently
+// used on the shared workers.
+func (s *SharedPool) Usage(p *WorkerPool) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.members {
+		if m.pool == p {
+			return m.usageAt(time.Now(), s.halfLife)
+		}
+	}
+	return 0
+}
+
+func (s *SharedPool) Add(ws []*Worker) {
+	s.mu.Lock()
+	s.pool = append(s.pool, ws...)
+	addFree(&s.free, ws, s.maxWorkerFailures)
+	s.mu.Unlock()
+	s.schedule()
+}
+
+func (s *SharedPool) Remove(selector func([]*Worker) []int) {
+	s.mu.Lock()
+	removeWorkers(&s.pool, &s.free, selector)
+	for _, m := range s.members {
+		for w := range m.inited {
+			if !containsWorker(s.pool, w) {
+				delete(m.inited, w)
+			}
+		}
+		for w := range m.initFails {
+			if !containsWorker(s.pool, w) {
+				delete(m.initFails, w)
+			}
+		}
+	}
+	for w := range s.lastInit {
+		if !containsWorker(s.pool, w) {
+			delete(s.lastInit, w)
+		}
+	}
+	s.mu.Unlock()
+	s.schedule()
+}
+
+// ResetFailures resets the count of tasks that w, a shared worker, has failed
+// and puts w back in service if it is idle.
+func (s *SharedPool) ResetFailures(w *Worker) {
+	w.ResetFailures()
+	s.mu.Lock()
+	if containsWorker(s.pool, w) {
+		addFree(&s.free, []*Worker{w}, s.maxWorkerFailures)
+	}
+	s.mu.Unlock()
+	s.schedule()
+}
+
+func containsWorker(ws []*Worker, w *Worker) bool {
+	for _, x := range ws {
+		if x == w {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SharedPool) WorkersCopy() []*Worker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Worker(nil), s.pool...)
+}
+
+func (s *SharedPool) schedule() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log.Infof("shared scheduler: nworkers: %d ngroups: %d", len(s.free), len(s.members))
+	dropDraining(&s.free)
+	now := time.Now()
+	alive := aliveWorkers(s.pool, s.maxWorkerFailures)
+	for i := 0; i < len(s.free); {
+		w := s.free[i]
+		m, t, reinit := s.take(w, alive, now)
+		if t == nil {
+			i++
+			continue
+		}
+		s.free = append(s.free[:i], s.free[i+1:]...)
+		m.running[t] = now
+		go s.run(m, w, t, reinit)
+	}
+}
+
+// take removes a task for w from the queue of the member that w should serve.
+// Members are tried in order of increasing weighted usage.
+// Members that w would have to be re-initialized for are skipped
+// while they have as many workers re-initializing as they allow.
+// take also reports whether w has to be re-initialized,
+// in which case the re-init has been counted with startReinit.
+//
+// Make sure that s.mu is held before calling this method!
+func (s *SharedPool) take(w *Worker, alive []*Worker, now time.Time) (*shareMember, *Task, bool) {
+	type candidate struct {
+		m              *shareMember
+		usage, running float64
+	}
+	var cands []candidate
+	for _, m := range s.members {
+		if m.initFails[w] > s.maxWorkerFailures || !m.pool.hasQueued() {
+			continue
+		}
+		cands = append(cands, candidate{
+			m:       m,
+			usage:   m.usageAt(now, s.halfLife) / m.weight,
+			running: float64(len(m.running)) / m.weight,
+		})
+	}
+	sort.Slice(cands, func(i, j int) bool {
+		if cands[i].usage != cands[j].usage {
+			return cands[i].usage < cands[j].usage
+		}
+		return cands[i].running < cands[j].running
+	})
+	for _, c := range cands {
+		var others []*Worker
+		for _, x := range alive {
+			if c.m.initFails[x] <= s.maxWorkerFailures {
+				others = append(others, x)
+			}
+		}
+		v := c.m.inited[w]
+		reinit := v != 0 && v != c.m.pool.InitVersion()
+		if reinit && !c.m.pool.startReinit() {
+			continue
+		}
+		if t := c.m.pool.take(w, others); t != nil {
+			return c.m, t, reinit
+		}
+		if reinit {
+			c.m.pool.endReinit()
+		}
+	}
+	return nil, nil, false
+}
+
+func (s *SharedPool) run(m *shareMember, w *Worker, t *Task, reinit bool) {
+	inited := s.initFor(m, w)
+	if reinit {
+		m.pool.endReinit()
+	}
+	if inited {
+		w.Run(t)
+		m.pool.keepResult(t)
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	m.charge(now.Sub(m.running[t]), now, s.halfLife)
+	delete(m.running, t)
+	addFree(&s.free, []*Worker{w}, s.maxWorkerFailures)
+	s.mu.Unlock()
+
+	if inited {
+		m.pool.finished(t)
+	} else {
+		m.pool.requeue(t)
+	}
+	s.schedule()
+}
+
+// initVersion returns the version of p's init task that w last completed,
+// or 0 if it has not completed one.
+func (s *SharedPool) initVersion(p *WorkerPool, w *Worker) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.members {
+		if m.pool == p {
+			return m.inited[w]
+		}
+	}
+	return 0
+}
+
+// initFor runs m's init task on w unless the last init task that w ran
+// is the current version of m's.
+func (s *SharedPool) initFor(m *shareMember, w *Worker) bool {
+	t, version := m.pool.versionedInit()
+	s.mu.Lock()
+	done := s.lastInit[w] == m && m.inited[w] == version
+	s.mu.Unlock()
+	if done {
+		return true
+	}
+
+	w.initMu.Lock()
+	w.init(t)
+	w.initMu.Unlock()
+	m.pool.initRan(w, t)
+	ok := t.Status().Err == nil
+
+	s.mu.Lock()
+	if ok {
+		m.inited[w] = version
+		s.lastInit[w] = m
+	} else {
+		m.initFails[w]++
+		delete(s.lastInit, w)
+	}
+	s.mu.Unlock()
+	return ok
+}
+
+func decay(d, halfLife time.Duration) float64 {
+	return math.Exp2(-d.Seconds() / halfLife.Seconds())
+}
+
+func (m *shareMember) usageAt(now time.Time, halfLife time.Duration) float64 {
+	u := m.usage * decay(now.Sub(m.updated), halfLife)
+	for _, start := range m.running {
+		u += now.Sub(start).Seconds()
+	}
+	return u
+}
+
+func (m *shareMember) charge(d time.Duration, now time.Time, halfLife time.Duration) {
+	m.usage = m.usage*decay(now.Sub(m.updated), halfLife) + d.Seconds()
+	m.updated = now
+}
@@ -0,0 +1,134 @@
+package bernie
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Infof(format string, args ...interface{})  {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
+
+func TestSharedPoolTake(t *testing.T) {
+	const halfLife = time.Hour
+	type member struct {
+		weight  float64
+		usage   float64
+		running int
+		queued  bool
+	}
+	tests := []struct {
+		name    string
+		members []member
+		want    int // index of the member served, or -1
+	}{
+		{
+			name:    "lowest usage",
+			members: []member{{weight: 1, usage: 20, queued: true}, {weight: 1, usage: 10, queued: true}},
+			want:    1,
+		},
+		{
+			name:    "usage relative to weight",
+			members: []member{{weight: 3, usage: 20, queued: true}, {weight: 1, usage: 10, queued: true}},
+			want:    0,
+		},
+		{
+			name:    "ties broken by running tasks",
+			members: []member{{weight: 1, running: 2, queued: true}, {weight: 2, running: 2, queued: true}},
+			want:    1,
+		},
+		{
+			name:    "skips members without queued tasks",
+			members: []member{{weight: 1, queued: false}, {weight: 1, usage: 100, queued: true}},
+			want:    1,
+		},
+		{
+			name:    "nothing queued",
+			members: []member{{weight: 1}, {weight: 2}},
+			want:    -1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewSharedPool(nopLogger{}, 3, halfLife)
+			now := time.Now()
+			var tasks []*Task
+			for i, mem := range test.members {
+				p := NewWorkerPool(nopLogger{}, 3, 3, nil)
+				s.Attach(p, mem.weight)
+				m := s.members[i]
+				m.usage = mem.usage
+				m.updated = now
+				for j := 0; j < mem.running; j++ {
+					m.running[&Task{}] = now
+				}
+				task := &Task{Name: "task"}
+				tasks = append(tasks, task)
+				if mem.queued {
+					p.Submit(task)
+				}
+			}
+
+			w := NewWorker(nopLogger{}, "w", "")
+			s.mu.Lock()
+			m, got, _ := s.take(w, []*Worker{w}, now)
+			s.mu.Unlock()
+			if test.want < 0 {
+				if got != nil {
+					t.Fatalf("took %v, want nothing", got.Name)
+				}
+				return
+			}
+			if got != tasks[test.want] || m != s.members[test.want] {
+				t.Errorf("took task of wrong member, want member %d", test.want)
+			}
+		})
+	}
+}
+
+func TestSharedPoolInitSwitch(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux is not installed")
+	}
+	dir, err := ioutil.TempDir("", "bernie-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each group's init task leaves the group's name in env,
+	// which the group's tasks check.
+	env := filepath.Join(dir, "env")
+	initTask := func(group string) *Task {
+		return &Task{Cmd: []string{"sh", "-c", "echo " + group + " > " + env}, WD: dir}
+	}
+
+	s := NewSharedPool(nopLogger{}, 3, time.Hour)
+	a := NewWorkerPool(nopLogger{}, 3, 1, initTask("a"))
+	b := NewWorkerPool(nopLogger{}, 3, 1, initTask("b"))
+	s.Attach(a, 1)
+	s.Attach(b, 1)
+	w := NewWorker(nopLogger{}, "w", "")
+	s.Add([]*Worker{w})
+
+	var ran []*Task // including init tasks, whose sessions are killed at the end
+	defer func() { cleanup(dir, w, ran...) }()
+	steps := []struct {
+		p     *WorkerPool
+		group string
+	}{{a, "a"}, {b, "b"}, {a, "a"}}
+	for i, step := range steps {
+		task := &Task{Name: fmt.Sprintf("%s-%d", step.group, i), Cmd: []string{"grep", "-qx", step.group, env}, WD: dir}
+		step.p.Submit(task)
+		st := waitFinished(t, step.p, task, 1)
+		ran = append(ran, task, w.Status().InitTask)
+		if st.Err != nil {
+			t.Fatalf("task %s ran without the init of group %s: %v", task.Name, step.group, st.Err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package bernie
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+var errNotRunning = errors.New("task is not running")
+
+// Suspend stops the processes of t's running attempt with SIGSTOP.
+// Time spent suspended is recorded in the attempt's Suspended field.
+func (t *Task) Suspend() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.status.IsRunning() || t.pgid == 0 {
+		return errNotRunning
+	}
+	if t.status.Suspended {
+		return nil
+	}
+	if err := stopPgroup(t.pgid); err != nil {
+		return err
+	}
+	t.status.Suspended = true
+	t.status.SuspendedAt = time.Now()
+	return nil
+}
+
+// Resume continues the processes of t's suspended attempt with SIGCONT.
+func (t *Task) Resume() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.status.IsRunning() || t.pgid == 0 {
+		return errNotRunning
+	}
+	if !t.status.Suspended {
+		return nil
+	}
+	if err := syscall.Kill(-t.pgid, syscall.SIGCONT); err != nil {
+		return err
+	}
+	t.status.SuspendedFor += time.Since(t.status.SuspendedAt)
+	t.status.Suspended = false
+	return nil
+}
+
+func (t *Task) setPgid(pgid int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pgid = pgid
+}
+
+// endSuspension forgets the process group of t's attempt,
+// which stopped running at end, and returns how long it was suspended.
+func (t *Task) endSuspension(end time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pgid = 0
+	if t.status.Suspended {
+		t.status.SuspendedFor += end.Sub(t.status.SuspendedAt)
+		t.status.Suspended = false
+	}
+	return t.status.SuspendedFor
+}
+
+// stopPgroup sends SIGSTOP to the processes in process group pgid
+// other than its leader, do.sh.
+// tmux continues a pane whose process stops, but do.sh only waits
+// for the task's command, so leaving it running is harmless.
+// Members are stopped until a pass finds none left running,
+// so processes forked in the meantime are also caught.
+func stopPgroup(pgid int) error {
+	stopped := make(map[int]bool)
+	for {
+		n := 0
+		for _, pid := range pgroupMembers(pgid) {
+			if pid == pgid || stopped[pid] {
+				continue
+			}
+			if err := syscall.Kill(pid, syscall.SIGSTOP); err != nil && err != syscall.ESRCH {
+				return err
+			}
+			stopped[pid] = true
+			n++
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
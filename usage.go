@@ -0,0 +1,231 @@
+package bernie
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Usage is the resource usage of one attempt of a task.
+type Usage struct {
+	UserCPU    time.Duration
+	SystemCPU  time.Duration
+	MaxRSS     int64 // bytes
+	ReadBytes  int64
+	WriteBytes int64
+
+	// Source is "cgroup" if the figures were read from a per-attempt cgroup
+	// and "pgroup" if they were gathered from the task's process group.
+	// It is empty if no figures were collected.
+	Source string
+}
+
+func (u Usage) String() string {
+	if u.Source == "" {
+		return "no usage"
+	}
+	return fmt.Sprintf("cpu %v user %v sys, rss %s, io %s read %s written",
+		u.UserCPU, u.SystemCPU, humanBytes(u.MaxRSS), humanBytes(u.ReadBytes), humanBytes(u.WriteBytes))
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Attempt records one run of a task on a worker.
+type Attempt struct {
+	Worker string
+	Start  time.Time
+	End    time.Time
+	// Suspended is how long the attempt was stopped by Task.Suspend
+	// between Start and End.
+	Suspended time.Duration
+	Err       error
+	Usage     Usage
+
+	// ExitCode is the shell status the command exited with,
+	// or -1 if it did not exit.
+	// It is kept even if the task's ExitPolicy counts it as success.
+	ExitCode int
+	// Signal is the name of the signal that killed the command, if any.
+	Signal string
+
+	// CachedFrom is the TASK/ATTEMPT whose result was reused
+	// if the task did not run.
+	CachedFrom string
+}
+
+// userHZ is the unit of the times in /proc/[pid]/stat.
+// It is 100 on every architecture Linux supports.
+const userHZ = 100
+
+// readCgroupUsage reads the usage of all processes that ran in the cgroup dir.
+func readCgroupUsage(dir string) (Usage, error) {
+	u := Usage{Source: "cgroup"}
+	stat, err := readKeyedFile(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return u, err
+	}
+	u.UserCPU = time.Duration(stat["user_usec"]) * time.Microsecond
+	u.SystemCPU = time.Duration(stat["system_usec"]) * time.Microsecond
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "memory.peak")); err == nil {
+		u.MaxRSS, _ = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "io.stat")); err == nil {
+		// lines look like: 8:0 rbytes=1 wbytes=2 rios=3 wios=4 ...
+		for _, line := range strings.Split(string(b), "\n") {
+			for _, f := range strings.Fields(line) {
+				kv := strings.SplitN(f, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				v, _ := strconv.ParseInt(kv[1], 10, 64)
+				switch kv[0] {
+				case "rbytes":
+					u.ReadBytes += v
+				case "wbytes":
+					u.WriteBytes += v
+				}
+			}
+		}
+	}
+	return u, nil
+}
+
+// removeCgroup removes the cgroup dir.
+// The last processes of an attempt may still be exiting, so it retries for a bit.
+func removeCgroup(dir string) error {
+	var err error
+	for i := 0; i < 20; i++ {
+		if err = os.Remove(dir); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return err
+}
+
+// readShellUsage reads the usage of the children of the task's shell.
+// do.sh saves the shell's stat and io files in wdir after the command exits,
+// so they include everything the shell waited for.
+func readShellUsage(wdir string) (Usage, error) {
+	u := Usage{Source: "pgroup"}
+	b, err := ioutil.ReadFile(filepath.Join(wdir, "stat"))
+	if err != nil {
+		return u, err
+	}
+	fields := statFields(string(b))
+	if len(fields) < 17 {
+		return u, fmt.Errorf("short stat file: %d fields", len(fields))
+	}
+	// fields 16 and 17 are cutime and cstime
+	cutime, _ := strconv.ParseInt(fields[15], 10, 64)
+	cstime, _ := strconv.ParseInt(fields[16], 10, 64)
+	u.UserCPU = time.Duration(cutime) * time.Second / userHZ
+	u.SystemCPU = time.Duration(cstime) * time.Second / userHZ
+	if io, err := readKeyedFile(filepath.Join(wdir, "io")); err == nil {
+		u.ReadBytes = io["read_bytes"]
+		u.WriteBytes = io["write_bytes"]
+	}
+	return u, nil
+}
+
+// statFields splits the contents of /proc/[pid]/stat into fields.
+// The command name is a single field even if it contains spaces.
+func statFields(stat string) []string {
+	lp := strings.IndexByte(stat, '(')
+	rp := strings.LastIndexByte(stat, ')')
+	if lp < 0 || rp < lp {
+		return strings.Fields(stat)
+	}
+	fields := strings.Fields(stat[:lp])
+	fields = append(fields, stat[lp:rp+1])
+	return append(fields, strings.Fields(stat[rp+1:])...)
+}
+
+// readKeyedFile parses files with "key value" or "key: value" lines.
+func readKeyedFile(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := make(map[string]int64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		m[strings.TrimSuffix(fields[0], ":")] = v
+	}
+	return m, s.Err()
+}
+
+// pgroupRSS returns the largest peak RSS of the live processes in process group pgid.
+func pgroupRSS(pgid int) int64 {
+	var peak int64
+	for _, pid := range pgroupMembers(pgid) {
+		status, err := readKeyedFile(filepath.Join("/proc", strconv.Itoa(pid), "status"))
+		if err != nil {
+			continue
+		}
+		if rss := status["VmHWM"] * 1024; rss > peak {
+			peak = rss
+		}
+	}
+	return peak
+}
+
+// pgroupMembers returns the pids of the live processes in process group pgid.
+func pgroupMembers(pgid int) []int {
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join("/proc", p.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		fields := statFields(string(b))
+		if len(fields) < 5 || fields[4] != strconv.Itoa(pgid) {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+// readPid reads the pid that do.sh saved in wdir.
+func readPid(wdir string) int {
+	b, err := ioutil.ReadFile(filepath.Join(wdir, "pid"))
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	return pid
+}